@@ -0,0 +1,319 @@
+package wal
+
+import "fmt"
+
+// GapPolicy controls how AppendAt and AppendEntries handle an LSN past the
+// next one
+type GapPolicy int
+
+const (
+	// GapReject rejects an LSN past the next one with an error wrapping
+	// ErrLSNOutOfRange.
+	GapReject GapPolicy = iota
+	// GapSkip accepts an LSN past the next one and never assigns the LSNs
+	// in between. A new segment is started at the LSN, so that its header
+	// records where the log resumes.
+	GapSkip
+)
+
+// AppendAt writes a new entry at the given LSN, as assigned by another log.
+//
+// The LSN must be the next one, or any later one when the Gaps option is
+// GapSkip. Otherwise an error wrapping ErrLSNOutOfRange is returned and
+// nothing is written.
+//
+// This method is thread-safe.
+func (w *WAL) AppendAt(lsn uint64, data []byte) error {
+	return w.AppendEntries([]*WAL_Entry{NewEntry(lsn, data)})
+}
+
+// AppendEntries writes entries that already carry their LSN, such as entries
+// read from another log by a replica or an import, preserving their LSNs and
+// metadata.
+//
+// The LSNs must be contiguous and follow the last LSN of the WAL, with gaps
+// allowed when the Gaps option is GapSkip. Every entry must carry a valid CRC
+// computed with any checksum algorithm, see EntryCRC: the CRC is kept when the
+// current segment uses the same algorithm and recomputed otherwise, so
+// corrupted entries are never written with a fresh CRC. Entries of a term older
+// than the current term, or than an earlier entry, fail with an error wrapping
+// ErrStaleTerm.
+//
+// The entries are checked before any is written, but are written one by one so
+// that TruncateBack can later remove them from any entry on: when a write fails,
+// the entries before it are kept. AppendBatch writes them atomically instead.
+// The CRC of the entries passed in may be updated.
+//
+// This method is thread-safe.
+func (w *WAL) AppendEntries(entries []*WAL_Entry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	if err := w.checkEntries(entries); err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.failure != nil {
+		return w.failure
+	}
+
+	// Check against the WAL before writing anything
+	if err := w.checkAppendEntries(entries); err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if err := w.appendLocked(entry); err != nil {
+			return fmt.Errorf("append entry %d: %w", entry.LogSequenceNumber, err)
+		}
+	}
+	return nil
+}
+
+// AppendBatch writes entries that already carry their LSN atomically in a
+// single record, like WriteBatch, so recovery either sees all of them or none.
+// Replicas use it to apply the batches of their source log.
+//
+// The entries are checked like those of AppendEntries, and their LSNs must also
+// be contiguous with each other: only the first one may follow a gap. Like the
+// entries of a Batch, they cannot be split by TruncateBack afterwards, so logs
+// that may be removed from any entry on, such as Raft logs, must be written
+// with AppendEntries instead. The CRC of the entries passed in may be updated.
+//
+// This method is thread-safe.
+func (w *WAL) AppendBatch(entries []*WAL_Entry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	for i := 1; i < len(entries); i++ {
+		if prev, lsn := entries[i-1].LogSequenceNumber, entries[i].LogSequenceNumber; lsn != prev+1 {
+			return fmt.Errorf("%w: got LSN %d in a batch, expected %d", ErrLSNOutOfRange, lsn, prev+1)
+		}
+	}
+	if err := w.checkEntries(entries); err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.failure != nil {
+		return w.failure
+	}
+
+	// Check against the WAL before writing anything
+	if err := w.checkAppendEntries(entries); err != nil {
+		return err
+	}
+
+	if err := w.appendBatchLocked(entries); err != nil {
+		return fmt.Errorf("append entries %d to %d: %w", entries[0].LogSequenceNumber, entries[len(entries)-1].LogSequenceNumber, err)
+	}
+	return nil
+}
+
+// checkEntries checks the checksums, LSNs and terms of
+// entries to append against each other
+func (w *WAL) checkEntries(entries []*WAL_Entry) error {
+	var term uint64
+	for i, entry := range entries {
+		if err := verifyAnyChecksum(entry); err != nil {
+			return fmt.Errorf("entry %d: %w", entry.LogSequenceNumber, err)
+		}
+		if i > 0 {
+			if err := w.checkNextLSN(entries[i-1].LogSequenceNumber, entry.LogSequenceNumber); err != nil {
+				return err
+			}
+		}
+		if entry.Term != nil {
+			if *entry.Term < term {
+				return fmt.Errorf("%w: entry %d has term %d after term %d", ErrStaleTerm, entry.LogSequenceNumber, *entry.Term, term)
+			}
+			term = *entry.Term
+		}
+	}
+	return nil
+}
+
+// checkAppendEntries checks that entries checked by checkEntries
+// may be appended without changing any state
+// it must be called with w.mu held
+func (w *WAL) checkAppendEntries(entries []*WAL_Entry) error {
+	if err := w.checkAppend(entries[0]); err != nil {
+		return fmt.Errorf("append entry %d: %w", entries[0].LogSequenceNumber, err)
+	}
+	// Terms never decrease, so the first term is the oldest
+	for _, entry := range entries {
+		if entry.Term != nil {
+			if err := w.checkStale(*entry.Term); err != nil {
+				return fmt.Errorf("append entry %d: %w", entry.LogSequenceNumber, err)
+			}
+			break
+		}
+	}
+	return nil
+}
+
+// appendLocked writes an entry that already carries its LSN,
+// which must follow the last LSN under the gap policy
+// the entry is checked before any state changes
+// checkpoints sync all prior entries first
+// it must be called with w.mu held
+func (w *WAL) appendLocked(entry *WAL_Entry) error {
+	if err := w.checkAppend(entry); err != nil {
+		return err
+	}
+
+	if entry.GetIsCheckpoint() {
+		if err := w.commitLocked(w.lastLSN); err != nil {
+			return fmt.Errorf("sync before checkpoint: %w", err)
+		}
+	}
+
+	lsn := entry.LogSequenceNumber
+	for {
+		// Check if rotation needed
+		if err := w.rotateIfNeeded(); err != nil {
+			return fmt.Errorf("rotate: %w", err)
+		}
+		if lsn == w.lastLSN+1 || w.syncRound == nil {
+			break
+		}
+		// Another writer may append while we wait, so check again
+		w.awaitSyncRound()
+	}
+
+	// The lock may have been released, so check again
+	if err := w.checkAppend(entry); err != nil {
+		return err
+	}
+	if err := w.checkTerm(entry); err != nil {
+		return err
+	}
+
+	if lsn > w.lastLSN+1 {
+		// Start a new segment at the LSN so that the
+		// header records where the log resumes
+		prev := w.lastLSN
+		w.lastLSN = lsn - 1
+		if err := w.rotate(); err != nil {
+			w.lastLSN = prev
+			return fmt.Errorf("rotate: %w", err)
+		}
+	}
+
+	if err := w.writeLocked(entry); err != nil {
+		return err
+	}
+	w.lastLSN = lsn
+	return nil
+}
+
+// appendBatchLocked writes contiguous entries that already carry
+// their LSNs atomically in a single batch frame
+// the first LSN must follow the last LSN under the gap policy
+// checkpoints sync all prior entries first
+// it must be called with w.mu held
+func (w *WAL) appendBatchLocked(entries []*WAL_Entry) error {
+	first := entries[0]
+	if err := w.checkAppend(first); err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.GetIsCheckpoint() {
+			if err := w.commitLocked(w.lastLSN); err != nil {
+				return fmt.Errorf("sync before checkpoint: %w", err)
+			}
+			break
+		}
+	}
+
+	lsn := first.LogSequenceNumber
+	for {
+		// Check if rotation needed
+		if err := w.rotateIfNeeded(); err != nil {
+			return fmt.Errorf("rotate: %w", err)
+		}
+		if w.syncRound == nil || (lsn == w.lastLSN+1 && usesFrames(w.entryWriter.header)) {
+			break
+		}
+		// Another writer may append while we wait, so check again
+		w.awaitSyncRound()
+	}
+
+	// The lock may have been released, so check again
+	if err := w.checkAppend(first); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := w.checkTerm(entry); err != nil {
+			return err
+		}
+	}
+
+	if lsn > w.lastLSN+1 || !usesFrames(w.entryWriter.header) {
+		// Start a new segment at the LSN so that the header records
+		// where the log resumes, batches also require frames
+		prev := w.lastLSN
+		w.lastLSN = lsn - 1
+		if err := w.rotate(); err != nil {
+			w.lastLSN = prev
+			return fmt.Errorf("rotate: %w", err)
+		}
+	}
+
+	algo := segmentChecksum(w.entryWriter.header)
+	for _, entry := range entries {
+		entry.CRC = entryCRC(algo, entry)
+	}
+	if err := w.entryWriter.WriteBatch(entries); err != nil {
+		return fmt.Errorf("write batch: %w", err)
+	}
+
+	for _, entry := range entries {
+		if entry.GetIsCheckpoint() {
+			w.checkpoint = &checkpointLocation{segmentID: w.currentSegment, lsn: entry.LogSequenceNumber}
+			w.checkpointScanned = true
+		}
+	}
+	w.lastLSN = entries[len(entries)-1].LogSequenceNumber
+	return nil
+}
+
+// checkAppend checks that an entry may be appended
+// without changing any state
+// it must be called with w.mu held
+func (w *WAL) checkAppend(entry *WAL_Entry) error {
+	if err := w.checkNextLSN(w.lastLSN, entry.LogSequenceNumber); err != nil {
+		return err
+	}
+	if entry.Term != nil {
+		return w.checkStale(*entry.Term)
+	}
+	return nil
+}
+
+// checkNextLSN checks that lsn may follow prev under the gap policy
+func (w *WAL) checkNextLSN(prev, lsn uint64) error {
+	if lsn == prev+1 || (lsn > prev+1 && w.options.Gaps == GapSkip) {
+		return nil
+	}
+	return fmt.Errorf("%w: got LSN %d, expected %d", ErrLSNOutOfRange, lsn, prev+1)
+}
+
+// verifyAnyChecksum verifies the CRC of an entry
+// computed with any of the checksum algorithms
+func verifyAnyChecksum(entry *WAL_Entry) error {
+	for algo := ChecksumIEEE; algo.valid(); algo++ {
+		if VerifyEntryWith(entry, algo) == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: no checksum algorithm matches", ErrCRCMismatch)
+}
@@ -0,0 +1,178 @@
+package wal
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+// termEntry builds an entry of the given term with a valid CRC
+func termEntry(lsn, term uint64, data string) *WAL_Entry {
+	entry := &WAL_Entry{LogSequenceNumber: lsn, Data: []byte(data), Term: &term}
+	entry.CRC = EntryCRC(ChecksumCRC32C, entry)
+	return entry
+}
+
+func TestAppendAtRequiresNextLSN(t *testing.T) {
+	w := openTestWAL(t, t.TempDir(), testOptions())
+
+	if err := w.AppendAt(1, []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.AppendAt(3, []byte("c")); !errors.Is(err, ErrLSNOutOfRange) {
+		t.Fatalf("AppendAt(3) error = %v, want ErrLSNOutOfRange", err)
+	}
+	if err := w.AppendAt(1, []byte("a")); !errors.Is(err, ErrLSNOutOfRange) {
+		t.Fatalf("AppendAt(1) error = %v, want ErrLSNOutOfRange", err)
+	}
+	if err := w.AppendEntries([]*WAL_Entry{NewEntry(2, nil), NewEntry(4, nil)}); !errors.Is(err, ErrLSNOutOfRange) {
+		t.Fatalf("AppendEntries() error = %v, want ErrLSNOutOfRange", err)
+	}
+	if got := w.LastLSN(); got != 1 {
+		t.Fatalf("LastLSN() = %d, want 1", got)
+	}
+}
+
+func TestAppendEntriesPreservesEntries(t *testing.T) {
+	w := openTestWAL(t, t.TempDir(), testOptions())
+
+	recordType := uint32(7)
+	source := &WAL_Entry{LogSequenceNumber: 1, Data: []byte("a"), RecordType: &recordType}
+	source.CRC = EntryCRC(ChecksumCRC32C, source)
+	if err := w.AppendEntries([]*WAL_Entry{source, termEntry(2, 3, "b")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	entry, err := w.Get(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.CRC != source.CRC || entry.GetRecordType() != recordType {
+		t.Fatalf("Get(1) = %v, want %v", entry, source)
+	}
+	if term, err := w.TermAt(2); err != nil || term != 3 {
+		t.Fatalf("TermAt(2) = %d, %v, want 3", term, err)
+	}
+	if got := w.Term(); got != 3 {
+		t.Fatalf("Term() = %d, want 3", got)
+	}
+}
+
+func TestAppendEntriesRecomputesOtherChecksums(t *testing.T) {
+	w := openTestWAL(t, t.TempDir(), testOptions())
+
+	// NewEntry uses ChecksumIEEE, the segment uses ChecksumCRC32C
+	if err := w.AppendAt(1, []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	entry, err := w.Get(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyEntryWith(entry, ChecksumCRC32C); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAppendEntriesRejectsCorruptEntries(t *testing.T) {
+	w := openTestWAL(t, t.TempDir(), testOptions())
+
+	entry := NewEntry(1, []byte("a"))
+	entry.Data = []byte("b")
+	if err := w.AppendEntries([]*WAL_Entry{entry}); !errors.Is(err, ErrCRCMismatch) {
+		t.Fatalf("AppendEntries() error = %v, want ErrCRCMismatch", err)
+	}
+	if got := w.LastLSN(); got != 0 {
+		t.Fatalf("LastLSN() = %d, want 0", got)
+	}
+}
+
+func TestAppendEntriesSkipsGaps(t *testing.T) {
+	dir := t.TempDir()
+	opts := testOptions()
+	opts.Gaps = GapSkip
+	w := openTestWAL(t, dir, opts)
+
+	if err := w.AppendAt(1, []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.AppendEntries([]*WAL_Entry{NewEntry(10, nil), NewEntry(20, nil)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Get(5); !errors.Is(err, ErrEntryNotFound) {
+		t.Fatalf("Get(5) error = %v, want ErrEntryNotFound", err)
+	}
+	if got := readLSNs(t, w); len(got) != 3 || got[1] != 10 || got[2] != 20 {
+		t.Fatalf("LSNs = %v, want [1 10 20]", got)
+	}
+	w.Close()
+
+	w = openTestWAL(t, dir, opts)
+	if lsn, err := w.WriteEntry([]byte("next")); err != nil || lsn != 21 {
+		t.Fatalf("WriteEntry() = %d, %v, want 21", lsn, err)
+	}
+}
+
+func TestAppendEntriesStaleTermLeavesStateUntouched(t *testing.T) {
+	opts := testOptions()
+	opts.Gaps = GapSkip
+	w := openTestWAL(t, t.TempDir(), opts)
+
+	if err := w.SetTerm(5); err != nil {
+		t.Fatal(err)
+	}
+	writeEntries(t, w, 3)
+	segments, err := w.segmentMgr.ListSegments()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.AppendEntries([]*WAL_Entry{termEntry(10, 3, "stale")}); !errors.Is(err, ErrStaleTerm) {
+		t.Fatalf("AppendEntries() error = %v, want ErrStaleTerm", err)
+	}
+	if err := w.AppendEntries([]*WAL_Entry{termEntry(4, 6, "new"), termEntry(5, 5, "old")}); !errors.Is(err, ErrStaleTerm) {
+		t.Fatalf("AppendEntries() error = %v, want ErrStaleTerm", err)
+	}
+
+	if got := w.LastLSN(); got != 3 {
+		t.Fatalf("LastLSN() = %d, want 3", got)
+	}
+	if got := w.Term(); got != 5 {
+		t.Fatalf("Term() = %d, want 5", got)
+	}
+	after, err := w.segmentMgr.ListSegments()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(after) != len(segments) {
+		t.Fatalf("segments = %v, want %v", after, segments)
+	}
+}
+
+func TestAppendBatchIsAtomic(t *testing.T) {
+	dir := t.TempDir()
+	w := openTestWAL(t, dir, testOptions())
+
+	if err := w.AppendBatch([]*WAL_Entry{NewEntry(1, nil), NewEntry(3, nil)}); !errors.Is(err, ErrLSNOutOfRange) {
+		t.Fatalf("AppendBatch(1, 3) error = %v, want ErrLSNOutOfRange", err)
+	}
+	if err := w.AppendBatch([]*WAL_Entry{NewEntry(1, []byte("a")), NewEntry(2, []byte("b")), NewEntry(3, []byte("c"))}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The entries are written in a single record
+	if err := w.TruncateBack(2); !errors.Is(err, ErrBatchSplit) {
+		t.Fatalf("TruncateBack(2) error = %v, want ErrBatchSplit", err)
+	}
+	w.Close()
+	w = openTestWAL(t, dir, testOptions())
+	if got := readLSNs(t, w); !slices.Equal(got, lsnRange(1, 3)) {
+		t.Fatalf("LSNs = %v, want 1 to 3", got)
+	}
+}
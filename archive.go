@@ -0,0 +1,204 @@
+package wal
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Archiver copies sealed segments to long-term storage, such as a backup
+// directory or an object store, for point-in-time recovery.
+//
+// Segments are archived in order in the background after every rotation, and
+// always before retention or TruncateFront deletes them. A segment that fails
+// to be archived is kept and retried on the next retention run. A segment may be
+// archived more than once, for example after a restart or after TruncateBack
+// reopened it, so ArchiveSegment must replace any previous copy. Archivers must
+// also implement ArchiveTruncater for TruncateBack to be used.
+type Archiver interface {
+	// ArchiveSegment archives the sealed segment with the given ID,
+	// r reads its whole content.
+	ArchiveSegment(id int, r io.Reader) error
+}
+
+// ArchiveTruncater is implemented by archivers that can remove archived segments.
+//
+// TruncateBack requires it when an Archiver is configured: the archived copies
+// of the segments it deletes or truncates hold the removed entries, and
+// restoring them would bring those entries back after the LSNs were reused.
+type ArchiveTruncater interface {
+	// TruncateArchive removes the archived segments with an ID greater
+	// than or equal to id. Removing segments that are not archived
+	// is not an error.
+	TruncateArchive(id int) error
+}
+
+// DirArchiver is an Archiver that copies segments to a local directory.
+//
+// Archived segments keep their "segment-N" file names, so the archive can be
+// read back with a FileSegmentManager and restored with RestoreSegments.
+type DirArchiver struct {
+	// directory is the directory holding the archived segments
+	directory string
+}
+
+// NewDirArchiver creates a DirArchiver storing segments in directory.
+//
+// The directory is created if it doesn't exist.
+func NewDirArchiver(directory string) (*DirArchiver, error) {
+	if err := os.MkdirAll(directory, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory: %w", err)
+	}
+	return &DirArchiver{directory: directory}, nil
+}
+
+// ArchiveSegment copies a segment into the archive directory.
+//
+// The copy is written to a temporary file that is synced and renamed into
+// place, so the archive never holds a partial segment.
+func (a *DirArchiver) ArchiveSegment(id int, r io.Reader) error {
+	path := filepath.Join(a.directory, fmt.Sprintf("%s%d", segmentPrefix, id))
+	tmp := path + ".tmp"
+
+	if err := copyFileSync(tmp, r); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("archive segment %d: %w", id, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("archive segment %d: %w", id, err)
+	}
+	return syncDir(a.directory)
+}
+
+// TruncateArchive removes the archived segments from id on, newest first,
+// so that the archive never has a gap.
+func (a *DirArchiver) TruncateArchive(id int) error {
+	ids, err := listSegmentFiles(a.directory)
+	if err != nil {
+		return err
+	}
+
+	for i := len(ids) - 1; i >= 0 && ids[i] >= id; i-- {
+		path := filepath.Join(a.directory, fmt.Sprintf("%s%d", segmentPrefix, ids[i]))
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("remove archived segment %d: %w", ids[i], err)
+		}
+	}
+	return syncDir(a.directory)
+}
+
+// copyFileSync writes the content of r to a file and syncs it to disk
+func copyFileSync(path string, r io.Reader) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, r); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// RestoreSegments copies the segments of an archive that dst does not hold.
+//
+// It is used to rebuild a WAL from its archive before opening it, typically
+// into an empty directory, followed by TruncateBack to recover to a point in
+// time:
+//
+//	archive, _ := wal.NewFileSegmentManager("./wal-archive")
+//	segMgr, _ := wal.NewFileSegmentManager("./wal-restored")
+//	if err := wal.RestoreSegments(segMgr, archive); err != nil {
+//		log.Fatal(err)
+//	}
+//
+// When dst holds segments, only the archived segments older than its first
+// segment are restored, so restoring into a live WAL directory only brings
+// back segments deleted by retention. Archived segments past the end of dst
+// are never restored, and TruncateBack removes the archived copies of the
+// segments it deletes or truncates.
+func RestoreSegments(dst SegmentManager, archive SegmentManager) error {
+	archived, err := archive.ListSegments()
+	if err != nil {
+		return fmt.Errorf("list archived segments: %w", err)
+	}
+	existing, err := dst.ListSegments()
+	if err != nil {
+		return fmt.Errorf("list segments: %w", err)
+	}
+
+	for _, id := range archived {
+		if len(existing) > 0 && id >= existing[0] {
+			break
+		}
+		if err := restoreSegment(dst, archive, id); err != nil {
+			return fmt.Errorf("restore segment %d: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// restoreSegment copies a single segment from the archive
+func restoreSegment(dst SegmentManager, archive SegmentManager, id int) error {
+	reader, err := archive.OpenSegment(id)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	writer, err := dst.CreateSegment(id)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(writer, reader); err != nil {
+		writer.Close()
+		return err
+	}
+	if syncer, ok := writer.(interface{ Sync() error }); ok {
+		if err := syncer.Sync(); err != nil {
+			writer.Close()
+			return err
+		}
+	}
+	return writer.Close()
+}
+
+// archiveSealed archives the sealed segments not archived yet
+// segments must be sealed and in ascending order
+// it must be called with w.retentionMu held
+func (w *WAL) archiveSealed(segments []int) error {
+	if w.options.Archiver == nil {
+		return nil
+	}
+
+	for _, segID := range segments {
+		if segID <= w.archivedThrough {
+			continue
+		}
+		if err := w.archiveSegment(segID); err != nil {
+			return err
+		}
+		w.archivedThrough = segID
+	}
+	return nil
+}
+
+// archiveSegment hands a segment to the archiver
+func (w *WAL) archiveSegment(segID int) error {
+	reader, err := w.segmentMgr.OpenSegment(segID)
+	if err != nil {
+		return fmt.Errorf("open segment %d: %w", segID, err)
+	}
+	defer reader.Close()
+
+	if err := w.options.Archiver.ArchiveSegment(segID, reader); err != nil {
+		return fmt.Errorf("archive segment %d: %w", segID, err)
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package wal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestRestoreSegmentsAfterTruncateBack(t *testing.T) {
+	dir := t.TempDir()
+	archiver, err := NewDirArchiver(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := truncateOptions()
+	opts.Archiver = archiver
+	opts.MaxSegments = 3
+	w := openTestWAL(t, dir, opts)
+	writeEntries(t, w, 50)
+	if err := w.ApplyRetention(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every sealed segment is archived, TruncateBack
+	// removes the copies of those it deletes
+	lsns := readLSNs(t, w)
+	cut := lsns[1]
+	if err := w.TruncateBack(cut); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	segmentMgr, err := NewFileSegmentManager(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive, err := NewFileSegmentManager(archiver.directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := RestoreSegments(segmentMgr, archive); err != nil {
+		t.Fatal(err)
+	}
+	if err := segmentMgr.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	w = openTestWAL(t, dir, testOptions())
+	if got := readLSNs(t, w); !slices.Equal(got, lsnRange(1, cut)) {
+		t.Fatalf("LSNs = %v, want 1 to %d", got, cut)
+	}
+}
+
+func TestRestoreSegmentsIntoEmptyDirectory(t *testing.T) {
+	archiver, err := NewDirArchiver(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := truncateOptions()
+	opts.Archiver = archiver
+	w := openTestWAL(t, t.TempDir(), opts)
+	writeEntries(t, w, 50)
+	if err := w.ApplyRetention(); err != nil {
+		t.Fatal(err)
+	}
+	archived := readLSNs(t, w)
+
+	dir := t.TempDir()
+	segmentMgr, err := NewFileSegmentManager(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive, err := NewFileSegmentManager(archiver.directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := RestoreSegments(segmentMgr, archive); err != nil {
+		t.Fatal(err)
+	}
+	if err := segmentMgr.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The current segment is not archived
+	restored := readLSNs(t, openTestWAL(t, dir, testOptions()))
+	if len(restored) == 0 || !slices.Equal(restored, archived[:len(restored)]) {
+		t.Fatalf("restored LSNs = %v, want a prefix of %v", restored, archived)
+	}
+}
+
+func TestRestoreSegmentsAfterTruncateBackAndRewrite(t *testing.T) {
+	archiver, err := NewDirArchiver(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := truncateOptions()
+	opts.Archiver = archiver
+	w := openTestWAL(t, t.TempDir(), opts)
+	writeEntries(t, w, 50)
+	if err := w.ApplyRetention(); err != nil {
+		t.Fatal(err)
+	}
+
+	// LSNs 6 onwards are written again and archived, the
+	// archived copies of the old ones must not come back
+	if err := w.TruncateBack(5); err != nil {
+		t.Fatal(err)
+	}
+	for i := 6; i <= 15; i++ {
+		if _, err := w.WriteEntry([]byte(fmt.Sprintf("rewritten %d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.ApplyRetention(); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	segmentMgr, err := NewFileSegmentManager(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive, err := NewFileSegmentManager(archiver.directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := RestoreSegments(segmentMgr, archive); err != nil {
+		t.Fatal(err)
+	}
+	if err := segmentMgr.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The current segment is not archived
+	var restored []uint64
+	for entry, err := range openTestWAL(t, dir, testOptions()).Entries(0) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lsn := entry.LogSequenceNumber; lsn > 5 && string(entry.Data) != fmt.Sprintf("rewritten %d", lsn) {
+			t.Fatalf("restored entry %d = %q, want the rewritten entry", lsn, entry.Data)
+		}
+		restored = append(restored, entry.LogSequenceNumber)
+	}
+	if len(restored) < 5 || len(restored) > 15 || !slices.Equal(restored, lsnRange(1, uint64(len(restored)))) {
+		t.Fatalf("restored LSNs = %v, want 1 to at least 5", restored)
+	}
+}
+
+func TestListSegmentsIgnoresTemporaryFiles(t *testing.T) {
+	dir := t.TempDir()
+	segmentMgr, err := NewFileSegmentManager(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer segmentMgr.Close()
+
+	for _, name := range []string{"segment-1", "segment-3.tmp", "segment-x", "segment-2"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ids, err := segmentMgr.ListSegments()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(ids, []int{1, 2}) {
+		t.Fatalf("ListSegments() = %v, want [1 2]", ids)
+	}
+}
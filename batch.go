@@ -0,0 +1,79 @@
+package wal
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// frameFlagBatch marks a frame whose payload holds several entries
+// written atomically by WriteBatch
+const frameFlagBatch uint32 = 1 << 9
+
+// Batch is a group of entries written atomically by WAL.WriteBatch.
+//
+// The zero value is an empty batch ready to use. A Batch is not safe for
+// concurrent use.
+type Batch struct {
+	// entries are the payloads of the entries in the batch
+	entries [][]byte
+}
+
+// Add appends an entry to the batch.
+//
+// The data is not copied and must not be modified until the batch is written.
+func (b *Batch) Add(data []byte) {
+	b.entries = append(b.entries, data)
+}
+
+// Len returns the number of entries in the batch.
+func (b *Batch) Len() int {
+	return len(b.entries)
+}
+
+// Reset empties the batch so it can be reused.
+func (b *Batch) Reset() {
+	clear(b.entries)
+	b.entries = b.entries[:0]
+}
+
+// encodeBatch encodes entries as the payload of a batch frame
+// each entry is prefixed with its length as a 4-byte little-endian uint32
+func encodeBatch(entries []*WAL_Entry) ([]byte, error) {
+	var buf []byte
+	for _, entry := range entries {
+		data, err := Marshal(entry)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal entry: %w", err)
+		}
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(len(data)))
+		buf = append(buf, data...)
+	}
+	return buf, nil
+}
+
+// decodeBatch decodes the payload of a batch frame
+func decodeBatch(data []byte) ([]*WAL_Entry, error) {
+	var entries []*WAL_Entry
+	for len(data) > 0 {
+		if len(data) < 4 {
+			return nil, fmt.Errorf("%w: batch ends inside a length prefix", ErrCorruptEntry)
+		}
+		size := binary.LittleEndian.Uint32(data)
+		data = data[4:]
+		if uint64(size) > uint64(len(data)) {
+			return nil, fmt.Errorf("%w: batch entry of %d bytes exceeds remaining %d", ErrCorruptEntry, size, len(data))
+		}
+
+		var entry WAL_Entry
+		if err := Unmarshal(data[:size], &entry); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrCorruptEntry, err)
+		}
+		entries = append(entries, &entry)
+		data = data[size:]
+	}
+
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("%w: empty batch", ErrCorruptEntry)
+	}
+	return entries, nil
+}
@@ -0,0 +1,95 @@
+package wal
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"testing"
+)
+
+// writeBatch writes a batch of n entries and returns the LSN of the first one
+func writeBatch(t *testing.T, w *WAL, n int) uint64 {
+	t.Helper()
+
+	var batch Batch
+	for i := range n {
+		batch.Add([]byte(fmt.Sprintf("batch entry %d", i)))
+	}
+	first, err := w.WriteBatch(&batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return first
+}
+
+func TestWriteBatch(t *testing.T) {
+	w := openTestWAL(t, t.TempDir(), testOptions())
+	writeEntries(t, w, 2)
+
+	if first := writeBatch(t, w, 3); first != 3 {
+		t.Fatalf("WriteBatch() = %d, want 3", first)
+	}
+	if got := w.LastLSN(); got != 5 {
+		t.Fatalf("LastLSN() = %d, want 5", got)
+	}
+	if lsn, err := w.WriteEntry([]byte("next")); err != nil || lsn != 6 {
+		t.Fatalf("WriteEntry() = %d, %v, want 6", lsn, err)
+	}
+
+	if got := readLSNs(t, w); !slices.Equal(got, lsnRange(1, 6)) {
+		t.Fatalf("LSNs = %v, want 1 to 6", got)
+	}
+	for i := range 3 {
+		entry, err := w.Get(uint64(3 + i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := fmt.Sprintf("batch entry %d", i); string(entry.Data) != want {
+			t.Fatalf("Get(%d) = %q, want %q", 3+i, entry.Data, want)
+		}
+	}
+}
+
+func TestWriteEmptyBatch(t *testing.T) {
+	w := openTestWAL(t, t.TempDir(), testOptions())
+	writeEntries(t, w, 2)
+
+	var batch Batch
+	batch.Add([]byte("reset"))
+	batch.Reset()
+	if first, err := w.WriteBatch(&batch); err != nil || first != 0 {
+		t.Fatalf("WriteBatch() = %d, %v, want 0", first, err)
+	}
+	if got := w.LastLSN(); got != 2 {
+		t.Fatalf("LastLSN() = %d, want 2", got)
+	}
+}
+
+func TestOpenDiscardsTornBatch(t *testing.T) {
+	dir := t.TempDir()
+	w := openTestWAL(t, dir, testOptions())
+	writeEntries(t, w, 2)
+	writeBatch(t, w, 3)
+	w.Close()
+
+	// Tear the last entry of the batch
+	path := segmentPath(dir, 0)
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(path, info.Size()-3); err != nil {
+		t.Fatal(err)
+	}
+
+	w = openTestWAL(t, dir, testOptions())
+	if got := w.LastLSN(); got != 2 {
+		t.Fatalf("LastLSN() = %d, want 2", got)
+	}
+	if first := writeBatch(t, w, 2); first != 3 {
+		t.Fatalf("WriteBatch() = %d, want 3", first)
+	}
+	if got := readLSNs(t, w); !slices.Equal(got, lsnRange(1, 4)) {
+		t.Fatalf("LSNs = %v, want 1 to 4", got)
+	}
+}
@@ -0,0 +1,220 @@
+package wal
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"math/bits"
+)
+
+// ChecksumAlgorithm identifies the checksum algorithm used by a segment.
+//
+// The algorithm is recorded in the segment header and used for both the entry
+// CRC and the frame checksum, so segments written with different algorithms
+// can coexist in the same WAL.
+type ChecksumAlgorithm uint8
+
+const (
+	// ChecksumIEEE is CRC-32 with the IEEE polynomial. It is used by
+	// header-less legacy segments.
+	ChecksumIEEE ChecksumAlgorithm = iota
+	// ChecksumCRC32C is CRC-32 with the Castagnoli polynomial, which is
+	// hardware accelerated on amd64 and arm64.
+	ChecksumCRC32C
+	// ChecksumXXHash64 is xxHash64 folded to 32 bits.
+	ChecksumXXHash64
+)
+
+// castagnoliTable is the CRC-32C table
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+// String returns the name of the checksum algorithm
+func (a ChecksumAlgorithm) String() string {
+	switch a {
+	case ChecksumIEEE:
+		return "crc32-ieee"
+	case ChecksumCRC32C:
+		return "crc32c"
+	case ChecksumXXHash64:
+		return "xxhash64"
+	default:
+		return fmt.Sprintf("ChecksumAlgorithm(%d)", uint8(a))
+	}
+}
+
+// valid reports whether the algorithm is known
+func (a ChecksumAlgorithm) valid() bool {
+	return a <= ChecksumXXHash64
+}
+
+// newHash returns a new hash for the algorithm
+func (a ChecksumAlgorithm) newHash() hash.Hash32 {
+	switch a {
+	case ChecksumCRC32C:
+		return crc32.New(castagnoliTable)
+	case ChecksumXXHash64:
+		return &foldedHash{newXXHash64()}
+	default:
+		return crc32.NewIEEE()
+	}
+}
+
+// foldedHash folds a 64-bit hash into 32 bits
+type foldedHash struct {
+	hash.Hash64
+}
+
+// Size returns the size of the folded checksum
+func (f *foldedHash) Size() int {
+	return 4
+}
+
+// Sum appends the folded checksum to b
+func (f *foldedHash) Sum(b []byte) []byte {
+	return binary.BigEndian.AppendUint32(b, f.Sum32())
+}
+
+// Sum32 returns the high and low halves of the 64-bit hash xor-ed together
+func (f *foldedHash) Sum32() uint32 {
+	sum := f.Sum64()
+	return uint32(sum) ^ uint32(sum>>32)
+}
+
+const (
+	xxPrime1 uint64 = 11400714785074694791
+	xxPrime2 uint64 = 14029467366897019727
+	xxPrime3 uint64 = 1609587929392839161
+	xxPrime4 uint64 = 9650029242287828579
+	xxPrime5 uint64 = 2870177450012600261
+)
+
+// xxHash64 is a streaming xxHash64 with a zero seed
+type xxHash64 struct {
+	// v1 to v4 are the stripe accumulators
+	v1, v2, v3, v4 uint64
+	// total is the number of bytes written
+	total uint64
+	// mem holds a partial stripe
+	mem [32]byte
+	// n is the number of bytes in mem
+	n int
+}
+
+// newXXHash64 returns a new xxHash64 digest
+func newXXHash64() *xxHash64 {
+	x := &xxHash64{}
+	x.Reset()
+	return x
+}
+
+// Reset resets the digest to its initial state
+func (x *xxHash64) Reset() {
+	// Wrap around in variables, the constants would overflow
+	prime1, prime2 := xxPrime1, xxPrime2
+	x.v1 = prime1 + prime2
+	x.v2 = prime2
+	x.v3 = 0
+	x.v4 = -prime1
+	x.total = 0
+	x.n = 0
+}
+
+// Size returns the size of the checksum
+func (x *xxHash64) Size() int {
+	return 8
+}
+
+// BlockSize returns the stripe size
+func (x *xxHash64) BlockSize() int {
+	return 32
+}
+
+// Write adds data to the digest
+func (x *xxHash64) Write(b []byte) (int, error) {
+	n := len(b)
+	x.total += uint64(n)
+
+	if x.n+len(b) < 32 {
+		x.n += copy(x.mem[x.n:], b)
+		return n, nil
+	}
+
+	if x.n > 0 {
+		c := copy(x.mem[x.n:], b)
+		x.stripe(x.mem[:])
+		b = b[c:]
+		x.n = 0
+	}
+
+	for ; len(b) >= 32; b = b[32:] {
+		x.stripe(b)
+	}
+
+	x.n = copy(x.mem[:], b)
+	return n, nil
+}
+
+// stripe consumes a 32-byte stripe
+func (x *xxHash64) stripe(b []byte) {
+	x.v1 = xxRound(x.v1, binary.LittleEndian.Uint64(b[0:8]))
+	x.v2 = xxRound(x.v2, binary.LittleEndian.Uint64(b[8:16]))
+	x.v3 = xxRound(x.v3, binary.LittleEndian.Uint64(b[16:24]))
+	x.v4 = xxRound(x.v4, binary.LittleEndian.Uint64(b[24:32]))
+}
+
+// Sum appends the checksum to b
+func (x *xxHash64) Sum(b []byte) []byte {
+	return binary.BigEndian.AppendUint64(b, x.Sum64())
+}
+
+// Sum64 returns the checksum
+func (x *xxHash64) Sum64() uint64 {
+	var h uint64
+	if x.total >= 32 {
+		h = bits.RotateLeft64(x.v1, 1) + bits.RotateLeft64(x.v2, 7) +
+			bits.RotateLeft64(x.v3, 12) + bits.RotateLeft64(x.v4, 18)
+		h = xxMergeRound(h, x.v1)
+		h = xxMergeRound(h, x.v2)
+		h = xxMergeRound(h, x.v3)
+		h = xxMergeRound(h, x.v4)
+	} else {
+		h = xxPrime5
+	}
+	h += x.total
+
+	b := x.mem[:x.n]
+	for ; len(b) >= 8; b = b[8:] {
+		h ^= xxRound(0, binary.LittleEndian.Uint64(b))
+		h = bits.RotateLeft64(h, 27)*xxPrime1 + xxPrime4
+	}
+	if len(b) >= 4 {
+		h ^= uint64(binary.LittleEndian.Uint32(b)) * xxPrime1
+		h = bits.RotateLeft64(h, 23)*xxPrime2 + xxPrime3
+		b = b[4:]
+	}
+	for _, c := range b {
+		h ^= uint64(c) * xxPrime5
+		h = bits.RotateLeft64(h, 11) * xxPrime1
+	}
+
+	h ^= h >> 33
+	h *= xxPrime2
+	h ^= h >> 29
+	h *= xxPrime3
+	h ^= h >> 32
+	return h
+}
+
+// xxRound mixes an input lane into an accumulator
+func xxRound(acc, input uint64) uint64 {
+	acc += input * xxPrime2
+	acc = bits.RotateLeft64(acc, 31)
+	return acc * xxPrime1
+}
+
+// xxMergeRound merges an accumulator into the final hash
+func xxMergeRound(acc, val uint64) uint64 {
+	acc ^= xxRound(0, val)
+	return acc*xxPrime1 + xxPrime4
+}
@@ -0,0 +1,68 @@
+package wal
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestXXHash64(t *testing.T) {
+	tests := []struct {
+		input string
+		want  uint64
+	}{
+		{"", 0xef46db3751d8e999},
+		{"a", 0xd24ec4f1a98c6e5b},
+		{"abc", 0x44bc2cf5ad770999},
+		{"Nobody inspects the spammish repetition", 0xfbcea83c8a378bf1},
+	}
+	for _, tt := range tests {
+		h := newXXHash64()
+		h.Write([]byte(tt.input))
+		if got := h.Sum64(); got != tt.want {
+			t.Errorf("xxHash64(%q) = %#x, want %#x", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestEntryChecksumAlgorithms(t *testing.T) {
+	algos := []ChecksumAlgorithm{ChecksumIEEE, ChecksumCRC32C, ChecksumXXHash64}
+	for _, algo := range algos {
+		entry := NewEntryWithChecksum(1, []byte("data"), algo)
+		for _, other := range algos {
+			err := VerifyEntryWith(entry, other)
+			if other == algo && err != nil {
+				t.Errorf("VerifyEntryWith(%v) error = %v", other, err)
+			}
+			if other != algo && !errors.Is(err, ErrCRCMismatch) {
+				t.Errorf("%v entry verified with %v: %v, want ErrCRCMismatch", algo, other, err)
+			}
+		}
+	}
+}
+
+func TestMixedChecksumSegments(t *testing.T) {
+	dir := t.TempDir()
+	for i, algo := range []ChecksumAlgorithm{ChecksumIEEE, ChecksumXXHash64, ChecksumCRC32C} {
+		opts := testOptions()
+		opts.Checksum = algo
+		w := openTestWAL(t, dir, opts)
+		w.mu.Lock()
+		err := w.rotate()
+		w.mu.Unlock()
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeEntries(t, w, 2)
+		w.Close()
+
+		if header := segmentHeader(t, dir, i+1); header.Checksum != algo {
+			t.Fatalf("segment %d uses %v, want %v", i+1, header.Checksum, algo)
+		}
+	}
+
+	w := openTestWAL(t, dir, testOptions())
+	if got := readLSNs(t, w); !slices.Equal(got, lsnRange(1, 6)) {
+		t.Fatalf("LSNs = %v, want 1 to 6", got)
+	}
+}
@@ -0,0 +1,151 @@
+package wal
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	sync "sync"
+)
+
+// Compression identifies the codec used to compress a record.
+//
+// The compression of each record is stored in its frame, so compressed and
+// uncompressed records can be mixed freely within and across segments.
+type Compression uint8
+
+const (
+	// CompressionNone stores records uncompressed
+	CompressionNone Compression = iota
+	// CompressionFlate compresses records with DEFLATE (RFC 1951)
+	CompressionFlate
+	// CompressionSnappy is reserved for a Snappy codec registered with RegisterCodec
+	CompressionSnappy
+	// CompressionZstd is reserved for a Zstandard codec registered with RegisterCodec
+	CompressionZstd
+	// CompressionLZ4 is reserved for an LZ4 codec registered with RegisterCodec
+	CompressionLZ4
+)
+
+// maxCompression is the largest compression ID that fits in a frame
+const maxCompression = 0xff
+
+// compressionMinSize is the smallest record worth compressing
+const compressionMinSize = 128 // 128B
+
+// String returns the name of the compression
+func (c Compression) String() string {
+	switch c {
+	case CompressionNone:
+		return "none"
+	case CompressionFlate:
+		return "flate"
+	case CompressionSnappy:
+		return "snappy"
+	case CompressionZstd:
+		return "zstd"
+	case CompressionLZ4:
+		return "lz4"
+	default:
+		return fmt.Sprintf("Compression(%d)", uint8(c))
+	}
+}
+
+// Codec compresses and decompresses record payloads.
+//
+// Codecs must be safe for concurrent use.
+type Codec interface {
+	// Compress returns the compressed form of src.
+	Compress(src []byte) ([]byte, error)
+	// Decompress returns the decompressed form of src, failing if it
+	// would exceed maxSize bytes.
+	Decompress(src []byte, maxSize int) ([]byte, error)
+}
+
+var (
+	// codecsMu is the mutex for the codec registry
+	codecsMu sync.RWMutex
+	// codecs are the registered codecs
+	codecs = map[Compression]Codec{
+		CompressionFlate: &flateCodec{},
+	}
+)
+
+// RegisterCodec makes a codec available under the given compression ID.
+//
+// Codecs must be registered before opening a WAL that writes or reads records
+// compressed with them, typically from an init function. The reserved IDs
+// CompressionSnappy, CompressionZstd and CompressionLZ4 should be used for the
+// respective algorithms so that segments stay readable across applications.
+//
+// RegisterCodec panics if the ID is CompressionNone or already registered.
+func RegisterCodec(compression Compression, codec Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+
+	if compression == CompressionNone {
+		panic("wal: RegisterCodec with CompressionNone")
+	}
+	if _, ok := codecs[compression]; ok {
+		panic(fmt.Sprintf("wal: RegisterCodec called twice for %v", compression))
+	}
+	codecs[compression] = codec
+}
+
+// lookupCodec returns the codec registered for the compression ID
+func lookupCodec(compression Compression) (Codec, error) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+
+	codec, ok := codecs[compression]
+	if !ok {
+		return nil, fmt.Errorf("%w: %v", ErrUnknownCodec, compression)
+	}
+	return codec, nil
+}
+
+// flateCodec compresses with DEFLATE
+type flateCodec struct {
+	// writers pools flate writers, which are expensive to create
+	writers sync.Pool
+}
+
+// Compress returns the DEFLATE-compressed form of src
+func (c *flateCodec) Compress(src []byte) ([]byte, error) {
+	var buf bytes.Buffer
+
+	fw, ok := c.writers.Get().(*flate.Writer)
+	if ok {
+		fw.Reset(&buf)
+	} else {
+		var err error
+		fw, err = flate.NewWriter(&buf, flate.BestSpeed)
+		if err != nil {
+			return nil, err
+		}
+	}
+	defer c.writers.Put(fw)
+
+	if _, err := fw.Write(src); err != nil {
+		return nil, err
+	}
+	if err := fw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decompress returns the decompressed form of DEFLATE-compressed src
+func (c *flateCodec) Decompress(src []byte, maxSize int) ([]byte, error) {
+	fr := flate.NewReader(bytes.NewReader(src))
+	defer fr.Close()
+
+	data, err := io.ReadAll(io.LimitReader(fr, int64(maxSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxSize {
+		return nil, fmt.Errorf("decompressed size exceeds maximum of %d", maxSize)
+	}
+	return data, nil
+}
@@ -0,0 +1,129 @@
+package wal
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"slices"
+	"testing"
+)
+
+// compressedSegment encodes a segment holding a single entry
+// written with CompressionFlate and returns it
+func compressedSegment(t *testing.T, data []byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	entryWriter := NewBinaryEntryWriter(&buf)
+	if err := entryWriter.SetCompression(CompressionFlate); err != nil {
+		t.Fatal(err)
+	}
+	if err := entryWriter.WriteHeader(newSegmentHeader(1, ChecksumCRC32C)); err != nil {
+		t.Fatal(err)
+	}
+	if err := entryWriter.WriteEntry(NewEntryWithChecksum(1, data, ChecksumCRC32C)); err != nil {
+		t.Fatal(err)
+	}
+	if err := entryWriter.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestCompressionRoundTrip(t *testing.T) {
+	random := make([]byte, 1024)
+	rand.Read(random)
+
+	tests := []struct {
+		name string
+		data []byte
+		// want is the compression recorded in the frame
+		want Compression
+	}{
+		{"compressible", bytes.Repeat([]byte("entry "), 200), CompressionFlate},
+		{"incompressible", random, CompressionNone},
+		{"small", []byte("entry"), CompressionNone},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := compressedSegment(t, tt.data)
+
+			fh := decodeFrameHeader(data[SegmentHeaderSize:])
+			if got := Compression(fh.flags & frameCompressionMask); got != tt.want {
+				t.Fatalf("frame compression = %v, want %v", got, tt.want)
+			}
+
+			entries, err := ReadAllEntries(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 1 || !bytes.Equal(entries[0].Data, tt.data) {
+				t.Fatalf("ReadAllEntries() did not return the entry written")
+			}
+		})
+	}
+}
+
+func TestUnknownCodec(t *testing.T) {
+	entryWriter := NewBinaryEntryWriter(&bytes.Buffer{})
+	if err := entryWriter.SetCompression(CompressionZstd); !errors.Is(err, ErrUnknownCodec) {
+		t.Fatalf("SetCompression() error = %v, want ErrUnknownCodec", err)
+	}
+
+	segmentMgr, err := NewFileSegmentManager(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := testOptions()
+	opts.Compression = CompressionZstd
+	if _, err := Open(segmentMgr, opts); !errors.Is(err, ErrUnknownCodec) {
+		t.Fatalf("Open() error = %v, want ErrUnknownCodec", err)
+	}
+
+	// Record a codec that is not registered in a valid frame
+	data := compressedSegment(t, bytes.Repeat([]byte("entry "), 200))
+	frame := data[SegmentHeaderSize:]
+	fh := decodeFrameHeader(frame)
+	flags := fh.flags&^frameCompressionMask | uint32(CompressionZstd)
+	fh = newFrameHeader(ChecksumCRC32C, flags, frame[frameHeaderSize:frameHeaderSize+fh.length])
+	fh.encode(frame)
+
+	if _, err := ReadAllEntries(bytes.NewReader(data)); !errors.Is(err, ErrUnknownCodec) {
+		t.Fatalf("ReadAllEntries() error = %v, want ErrUnknownCodec", err)
+	}
+}
+
+func TestMixedCompressionSegments(t *testing.T) {
+	dir := t.TempDir()
+	data := bytes.Repeat([]byte("entry "), 200)
+
+	for _, compression := range []Compression{CompressionFlate, CompressionNone, CompressionFlate} {
+		opts := testOptions()
+		opts.Compression = compression
+		w := openTestWAL(t, dir, opts)
+		for range 2 {
+			if _, err := w.WriteEntry(data); err != nil {
+				t.Fatal(err)
+			}
+		}
+		w.Close()
+	}
+
+	w := openTestWAL(t, dir, testOptions())
+	if err := w.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	var lsns []uint64
+	for entry, err := range w.Entries(0) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(entry.Data, data) {
+			t.Fatalf("entry %d holds %d bytes, want %d", entry.LogSequenceNumber, len(entry.Data), len(data))
+		}
+		lsns = append(lsns, entry.LogSequenceNumber)
+	}
+	if !slices.Equal(lsns, lsnRange(1, 6)) {
+		t.Fatalf("LSNs = %v, want 1 to 6", lsns)
+	}
+}
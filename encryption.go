@@ -0,0 +1,244 @@
+package wal
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// SegmentFlagEncrypted marks a segment whose records are encrypted
+	// with the key identified by SegmentHeader.KeyID
+	SegmentFlagEncrypted uint16 = 1 << 0
+
+	// frameFlagEncrypted marks a frame whose payload is encrypted
+	frameFlagEncrypted uint32 = 1 << 8
+
+	// encryptionNonceSize is the size in bytes of the AES-GCM nonce
+	// stored in front of every encrypted payload
+	encryptionNonceSize = 12
+	// encryptionOverhead is the number of bytes encryption adds
+	// to a payload, the nonce and the authentication tag
+	encryptionOverhead = encryptionNonceSize + 16
+
+	// keyFileSuffix is the suffix of key files
+	keyFileSuffix = ".key"
+	// keySize is the size in bytes of keys generated by FileKeyProvider
+	keySize = 32 // AES-256
+)
+
+// KeyProvider supplies the keys used to encrypt segments at rest.
+//
+// Every new segment is encrypted with the current key and records its ID in
+// the segment header. Rotating the current key therefore takes effect on the
+// next segment, or on Open, while segments written with older keys stay
+// readable as long as Key still returns them. Records are authenticated
+// together with the base LSN of their segment.
+//
+// Keys must be 16, 24 or 32 bytes long, selecting AES-128, AES-192 or AES-256.
+// KeyProvider implementations must be safe for concurrent use.
+type KeyProvider interface {
+	// CurrentKeyID returns the ID of the key to encrypt new segments with.
+	CurrentKeyID() (uint32, error)
+	// Key returns the key with the given ID.
+	Key(id uint32) ([]byte, error)
+}
+
+// newSegmentCipher returns the AEAD for a segment encrypted with the key
+// identified by its header
+func newSegmentCipher(keys KeyProvider, header *SegmentHeader) (cipher.AEAD, error) {
+	if header == nil || header.Flags&SegmentFlagEncrypted == 0 {
+		return nil, nil
+	}
+	if keys == nil {
+		return nil, fmt.Errorf("%w: segment is encrypted with key %d but no key provider is set", ErrKeyNotFound, header.KeyID)
+	}
+
+	key, err := keys.Key(header.KeyID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: key %d: %v", ErrKeyNotFound, header.KeyID, err)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("key %d: %w", header.KeyID, err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// segmentAD returns the additional data authenticated with every
+// record of a segment, its base LSN, so that a record copied into
+// another segment fails to decrypt
+func segmentAD(header *SegmentHeader) []byte {
+	return binary.LittleEndian.AppendUint64(nil, header.BaseLSN)
+}
+
+// encrypt seals a payload with a random nonce
+// the nonce is stored in front of the ciphertext
+func encrypt(aead cipher.AEAD, data, ad []byte) ([]byte, error) {
+	out := make([]byte, encryptionNonceSize, encryptionNonceSize+len(data)+aead.Overhead())
+	if _, err := rand.Read(out); err != nil {
+		return nil, fmt.Errorf("generate nonce: %w", err)
+	}
+	return aead.Seal(out, out, data, ad), nil
+}
+
+// decrypt opens a payload sealed by encrypt with the same additional data
+func decrypt(aead cipher.AEAD, data, ad []byte) ([]byte, error) {
+	if len(data) < encryptionOverhead {
+		return nil, fmt.Errorf("%w: payload of %d bytes is too short", ErrDecryptionFailed, len(data))
+	}
+	plaintext, err := aead.Open(nil, data[:encryptionNonceSize], data[encryptionNonceSize:], ad)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDecryptionFailed, err)
+	}
+	return plaintext, nil
+}
+
+// FileKeyProvider is a KeyProvider that stores keys as files in a directory.
+//
+// Each key is stored hex-encoded in a file named "<id>.key", and the key with
+// the highest ID is the current key. Keys are never deleted, so segments
+// encrypted with rotated keys remain readable.
+type FileKeyProvider struct {
+	// dir is the directory holding the key files
+	dir string
+	// mu guards the fields below
+	mu sync.Mutex
+	// currentID is the ID of the current key
+	currentID uint32
+	// keys caches the keys read so far
+	keys map[uint32][]byte
+}
+
+// NewFileKeyProvider creates a FileKeyProvider storing keys in dir.
+//
+// The directory is created if it does not exist, and a first key is generated
+// if it holds no keys.
+func NewFileKeyProvider(dir string) (*FileKeyProvider, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("create key directory: %w", err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read key directory: %w", err)
+	}
+
+	kp := &FileKeyProvider{
+		dir:  dir,
+		keys: make(map[uint32][]byte),
+	}
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), keyFileSuffix)
+		if !ok {
+			continue
+		}
+		id, err := strconv.ParseUint(name, 10, 32)
+		if err != nil {
+			continue
+		}
+		kp.currentID = max(kp.currentID, uint32(id))
+	}
+
+	if kp.currentID == 0 {
+		if _, err := kp.Rotate(); err != nil {
+			return nil, err
+		}
+	}
+	return kp, nil
+}
+
+// CurrentKeyID returns the ID of the newest key.
+func (kp *FileKeyProvider) CurrentKeyID() (uint32, error) {
+	kp.mu.Lock()
+	defer kp.mu.Unlock()
+	return kp.currentID, nil
+}
+
+// Key returns the key with the given ID.
+func (kp *FileKeyProvider) Key(id uint32) ([]byte, error) {
+	kp.mu.Lock()
+	defer kp.mu.Unlock()
+
+	if key, ok := kp.keys[id]; ok {
+		return key, nil
+	}
+
+	data, err := os.ReadFile(kp.keyPath(id))
+	if err != nil {
+		return nil, fmt.Errorf("read key %d: %w", id, err)
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("decode key %d: %w", id, err)
+	}
+
+	kp.keys[id] = key
+	return key, nil
+}
+
+// Rotate generates a new random key and makes it the current key.
+//
+// Segments created afterwards are encrypted with the new key. Returns the ID
+// of the new key.
+func (kp *FileKeyProvider) Rotate() (uint32, error) {
+	kp.mu.Lock()
+	defer kp.mu.Unlock()
+
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return 0, fmt.Errorf("generate key: %w", err)
+	}
+
+	id := kp.currentID + 1
+	path := kp.keyPath(id)
+	tmp := path + ".tmp"
+	if err := writeKeyFile(tmp, key); err != nil {
+		os.Remove(tmp)
+		return 0, fmt.Errorf("write key %d: %w", id, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return 0, fmt.Errorf("write key %d: %w", id, err)
+	}
+	// The key must survive a crash once segments use it
+	if err := syncDir(kp.dir); err != nil {
+		return 0, fmt.Errorf("write key %d: %w", id, err)
+	}
+
+	kp.keys[id] = key
+	kp.currentID = id
+	return id, nil
+}
+
+// writeKeyFile writes a hex-encoded key and syncs it to disk
+// losing a key makes the segments encrypted with it unreadable
+func writeKeyFile(path string, key []byte) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.WriteString(hex.EncodeToString(key)); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// keyPath returns the path of the key file for the given ID
+func (kp *FileKeyProvider) keyPath(id uint32) string {
+	return filepath.Join(kp.dir, fmt.Sprintf("%d%s", id, keyFileSuffix))
+}
@@ -0,0 +1,194 @@
+package wal
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"slices"
+	"testing"
+)
+
+// segmentHeader reads the header of a segment file in dir
+func segmentHeader(t *testing.T, dir string, id int) *SegmentHeader {
+	t.Helper()
+
+	file, err := os.Open(segmentPath(dir, id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	header, err := NewBinaryEntryReader(file).Header()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return header
+}
+
+func TestEncryptedSegments(t *testing.T) {
+	dir := t.TempDir()
+	keys, err := NewFileKeyProvider(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := testOptions()
+	opts.KeyProvider = keys
+
+	w := openTestWAL(t, dir, opts)
+	if _, err := w.WriteEntry([]byte("secret entry")); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	data, err := os.ReadFile(segmentPath(dir, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("secret entry")) {
+		t.Fatal("segment holds the entry in plaintext")
+	}
+
+	w = openTestWAL(t, dir, opts)
+	entry, err := w.Get(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(entry.Data) != "secret entry" {
+		t.Fatalf("entry data = %q, want %q", entry.Data, "secret entry")
+	}
+}
+
+func TestOpenRotatesWhenEncryptionEnabled(t *testing.T) {
+	dir := t.TempDir()
+	w := openTestWAL(t, dir, testOptions())
+	writeEntries(t, w, 3)
+	w.Close()
+
+	keys, err := NewFileKeyProvider(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := testOptions()
+	opts.KeyProvider = keys
+	w = openTestWAL(t, dir, opts)
+	writeEntries(t, w, 3)
+	if got := readLSNs(t, w); !slices.Equal(got, lsnRange(1, 6)) {
+		t.Fatalf("LSNs = %v, want 1 to 6", got)
+	}
+
+	if header := segmentHeader(t, dir, 0); header.Flags&SegmentFlagEncrypted != 0 {
+		t.Fatal("segment 0 was encrypted")
+	}
+	if header := segmentHeader(t, dir, 1); header.Flags&SegmentFlagEncrypted == 0 || header.BaseLSN != 4 {
+		t.Fatalf("segment 1 header = %+v, want an encrypted segment starting at LSN 4", header)
+	}
+}
+
+func TestOpenRotatesAfterKeyRotation(t *testing.T) {
+	dir := t.TempDir()
+	keys, err := NewFileKeyProvider(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := testOptions()
+	opts.KeyProvider = keys
+
+	w := openTestWAL(t, dir, opts)
+	writeEntries(t, w, 3)
+	w.Close()
+
+	keyID, err := keys.Rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w = openTestWAL(t, dir, opts)
+	writeEntries(t, w, 3)
+	if got := readLSNs(t, w); !slices.Equal(got, lsnRange(1, 6)) {
+		t.Fatalf("LSNs = %v, want 1 to 6", got)
+	}
+	if header := segmentHeader(t, dir, 1); header.KeyID != keyID {
+		t.Fatalf("segment 1 uses key %d, want %d", header.KeyID, keyID)
+	}
+}
+
+func TestEncryptedRecordBoundToSegment(t *testing.T) {
+	keys, err := NewFileKeyProvider(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyID, err := keys.CurrentKeyID()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	entryWriter := NewBinaryEntryWriter(&buf)
+	entryWriter.SetKeyProvider(keys)
+	header := newSegmentHeader(1, ChecksumCRC32C)
+	header.Flags |= SegmentFlagEncrypted
+	header.KeyID = keyID
+	if err := entryWriter.WriteHeader(header); err != nil {
+		t.Fatal(err)
+	}
+	entry := NewEntry(1, []byte("data"))
+	entry.CRC = EntryCRC(ChecksumCRC32C, entry)
+	if err := entryWriter.WriteEntry(entry); err != nil {
+		t.Fatal(err)
+	}
+	if err := entryWriter.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Move the record into a segment starting at another LSN
+	moved := *header
+	moved.BaseLSN = 5
+	data, err := moved.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := append(data, buf.Bytes()[SegmentHeaderSize:]...)
+
+	entryReader := NewBinaryEntryReader(bytes.NewReader(record))
+	entryReader.SetKeyProvider(keys)
+	if _, err := entryReader.ReadEntry(); !errors.Is(err, ErrDecryptionFailed) {
+		t.Fatalf("ReadEntry() error = %v, want ErrDecryptionFailed", err)
+	}
+
+	entryReader = NewBinaryEntryReader(bytes.NewReader(buf.Bytes()))
+	entryReader.SetKeyProvider(keys)
+	if _, err := entryReader.ReadEntry(); err != nil {
+		t.Fatalf("ReadEntry() error = %v", err)
+	}
+}
+
+func TestTruncateBackRotatesAfterKeyRotation(t *testing.T) {
+	dir := t.TempDir()
+	keys, err := NewFileKeyProvider(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := truncateOptions()
+	opts.KeyProvider = keys
+
+	w := openTestWAL(t, dir, opts)
+	writeEntries(t, w, 20)
+	keyID, err := keys.Rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Appending to segment 0 would keep using the old key
+	if err := w.TruncateBack(2); err != nil {
+		t.Fatal(err)
+	}
+	writeEntries(t, w, 3)
+	if header := segmentHeader(t, dir, w.currentSegment); header.KeyID != keyID || header.BaseLSN != 3 {
+		t.Fatalf("segment %d header = %+v, want key %d and base LSN 3", w.currentSegment, header, keyID)
+	}
+
+	w.Close()
+	w = openTestWAL(t, dir, opts)
+	if got := readLSNs(t, w); !slices.Equal(got, lsnRange(1, 5)) {
+		t.Fatalf("LSNs = %v, want 1 to 5", got)
+	}
+}
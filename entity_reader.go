@@ -2,6 +2,8 @@ package wal
 
 import (
 	"bufio"
+	"bytes"
+	"crypto/cipher"
 	"encoding/binary"
 	"fmt"
 	"io"
@@ -18,50 +20,398 @@ type EntryReader interface {
 
 // BinaryEntryReader reads entries in binary format with a length prefix.
 //
-// The binary format consists of:
+// If the stream starts with a SegmentHeader it is validated and skipped, and
+// its format version selects how records are framed. Segments of format version
+// 2 and later store each entry in a checksummed frame, whose flags record
+// whether the entry is compressed or encrypted:
 //   - 4 bytes: uint32 length of the protobuf-encoded entry (little-endian)
+//   - 4 bytes: uint32 frame flags (little-endian)
+//   - 4 bytes: uint32 checksum of the length and flags (little-endian)
+//   - 4 bytes: uint32 checksum of the length, flags and entry (little-endian)
 //   - N bytes: protobuf-encoded WAL_Entry
 //
+// Header-less legacy segments and format version 1 consist of:
+//   - 4 bytes: uint32 length of the protobuf-encoded entry (little-endian)
+//   - N bytes: protobuf-encoded WAL_Entry
+//
+// In both formats, lengths above the maximum record size are rejected before
+// any allocation. Framed segments also verify the header checksum first, so a
+// corrupted length is reported as a checksum failure rather than trusted.
+//
 // BinaryEntryReader uses buffering for efficient reading of sequential entries.
 type BinaryEntryReader struct {
 	// r is the underlying reader
 	r io.Reader
 	// br is the buffered reader
 	br *bufio.Reader
+	// offset is the number of bytes consumed by
+	// the entries read so far
+	offset int64
+	// entryOffset is the offset where the last
+	// entry read starts
+	entryOffset int64
+	// segmentID is the segment being read
+	// it is -1 when not reading from a segment
+	segmentID int
+	// header is the segment header
+	// it is nil for legacy segments
+	header *SegmentHeader
+	// headerRead is whether the header was read
+	headerRead bool
+	// maxRecordSize is the largest record accepted
+	maxRecordSize int
+	// keys supplies the keys of encrypted segments
+	keys KeyProvider
+	// aead decrypts records of an encrypted segment
+	// it is resolved from keys on the first encrypted record
+	aead cipher.AEAD
+	// pending are the remaining entries of the batch
+	// being read, they share the offset of its frame
+	pending []*WAL_Entry
+	// heartbeats is whether the heartbeat frames of
+	// a replication stream are accepted and skipped
+	heartbeats bool
 }
 
 // NewBinaryEntryReader creates a new BinaryEntryReader that reads from r.
 //
 // The reader is buffered with a 4KB buffer for optimal performance.
 func NewBinaryEntryReader(r io.Reader) *BinaryEntryReader {
+	return newSegmentEntryReader(r, -1)
+}
+
+// newSegmentEntryReader creates a BinaryEntryReader for the given segment
+// so that corruption errors report the segment ID.
+func newSegmentEntryReader(r io.Reader, segmentID int) *BinaryEntryReader {
 	return &BinaryEntryReader{
-		r:  r,
-		br: bufio.NewReaderSize(r, defaultBufferSize),
+		r:             r,
+		br:            bufio.NewReaderSize(r, defaultBufferSize),
+		segmentID:     segmentID,
+		maxRecordSize: defaultMaxRecordSize,
 	}
 }
 
+// SetMaxRecordSize sets the largest record the reader accepts, in bytes.
+//
+// Larger length prefixes are reported as corruption without allocating.
+// The default is 64MB.
+func (ber *BinaryEntryReader) SetMaxRecordSize(size int) {
+	ber.maxRecordSize = size
+}
+
+// SetKeyProvider sets the provider of the keys used to decrypt encrypted segments.
+//
+// Reading a record of an encrypted segment without a key provider, or whose
+// key the provider does not know, fails with an error wrapping ErrKeyNotFound.
+func (ber *BinaryEntryReader) SetKeyProvider(keys KeyProvider) {
+	ber.keys = keys
+}
+
 // ReadEntry reads the next WAL entry from the reader.
 //
-// ReadEntry first reads a 4-byte length prefix, then reads that many bytes
-// and unmarshals them as a protobuf-encoded WAL_Entry.
+// ReadEntry first reads the record length and, for framed segments, validates
+// the frame checksum, then unmarshals the record as a protobuf-encoded WAL_Entry.
+//
+// Returns io.EOF when no more entries are available. A partial entry yields a
+// *CorruptionError wrapping ErrTruncatedEntry, a frame checksum failure one
+// wrapping ErrCRCMismatch and an entry that cannot be decoded one wrapping
+// ErrCorruptEntry.
 //
-// Returns io.EOF when no more entries are available.
+// Entries of a batch written by WriteBatch are returned one by one and all
+// report the offset of the batch. A record of an encrypted segment that fails authentication
+// yields an error wrapping ErrDecryptionFailed.
 func (ber *BinaryEntryReader) ReadEntry() (*WAL_Entry, error) {
+	if len(ber.pending) > 0 {
+		entry := ber.pending[0]
+		ber.pending = ber.pending[1:]
+		return entry, nil
+	}
+
+	header, err := ber.Header()
+	if err != nil {
+		return nil, err
+	}
+
+	var data []byte
+	var flags uint32
+	var size int
+	for {
+		if usesFrames(header) {
+			data, flags, size, err = ber.readFrame()
+		} else {
+			data, size, err = ber.readLengthPrefixed()
+		}
+		if err != nil {
+			return nil, err
+		}
+		if flags&frameFlagHeartbeat == 0 {
+			break
+		}
+		ber.offset += int64(size)
+	}
+
+	if flags&frameFlagBatch != 0 {
+		entries, err := decodeBatch(data)
+		if err != nil {
+			return nil, ber.corruption(err)
+		}
+		ber.pending = entries[1:]
+		ber.entryOffset = ber.offset
+		ber.offset += int64(size)
+		return entries[0], nil
+	}
+
+	// Unmarshal entry
+	var entry WAL_Entry
+	if err := Unmarshal(data, &entry); err != nil {
+		return nil, ber.corruption(fmt.Errorf("%w: %v", ErrCorruptEntry, err))
+	}
+
+	ber.entryOffset = ber.offset
+	ber.offset += int64(size)
+	return &entry, nil
+}
+
+// readLengthPrefixed reads a record preceded by its length
+// it returns the record and the number of bytes consumed
+func (ber *BinaryEntryReader) readLengthPrefixed() ([]byte, int, error) {
 	// Read length prefix
 	var size uint32
 	if err := binary.Read(ber.br, binary.LittleEndian, &size); err != nil {
-		return nil, err // Will be io.EOF at end of file
+		if err == io.ErrUnexpectedEOF {
+			return nil, 0, ber.corruption(fmt.Errorf("%w: read size: %v", ErrTruncatedEntry, err))
+		}
+		return nil, 0, err // Will be io.EOF at end of file
+	}
+
+	if err := ber.checkRecordSize(size); err != nil {
+		return nil, 0, err
 	}
 
 	// Read entry data
+	data, err := ber.readPayload(size)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return data, 4 + len(data), nil
+}
+
+// readFrame reads a checksummed frame, validates it, decrypts it and decompresses it
+// it returns the record, the frame flags and the number of bytes consumed
+func (ber *BinaryEntryReader) readFrame() ([]byte, uint32, int, error) {
+	// Read frame header
+	var buf [frameHeaderSize]byte
+	if _, err := io.ReadFull(ber.br, buf[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, 0, 0, ber.corruption(fmt.Errorf("%w: read frame header: %v", ErrTruncatedEntry, err))
+		}
+		return nil, 0, 0, err // Will be io.EOF at end of file
+	}
+	fh := decodeFrameHeader(buf[:])
+	algo := segmentChecksum(ber.header)
+
+	// Trust the length only once the header checksum matches
+	if expected := frameHeaderChecksum(algo, fh.length, fh.flags); fh.headerChecksum != expected {
+		return nil, 0, 0, ber.corruption(fmt.Errorf("%w: frame header checksum: expected %d, got %d", ErrCRCMismatch, expected, fh.headerChecksum))
+	}
+
+	// Validate the length before allocating
+	length := fh.length
+	if fh.flags&frameFlagEncrypted != 0 && length >= encryptionOverhead {
+		length -= encryptionOverhead
+	}
+	if err := ber.checkRecordSize(length); err != nil {
+		return nil, 0, 0, err
+	}
+
+	// Read entry data
+	data, err := ber.readPayload(fh.length)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	expected := frameChecksum(algo, fh.length, fh.flags, data)
+	if fh.checksum != expected {
+		return nil, 0, 0, ber.corruption(fmt.Errorf("%w: frame checksum: expected %d, got %d", ErrCRCMismatch, expected, fh.checksum))
+	}
+
+	known := frameCompressionMask | frameFlagEncrypted | frameFlagBatch
+	if ber.heartbeats {
+		known |= frameFlagHeartbeat
+	}
+	if fh.flags&^known != 0 {
+		return nil, 0, 0, ber.corruption(fmt.Errorf("%w: unknown frame flags %#x", ErrCorruptEntry, fh.flags))
+	}
+
+	size := frameHeaderSize + len(data)
+	if fh.flags&frameFlagEncrypted != 0 {
+		aead, err := ber.cipher()
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		if data, err = decrypt(aead, data, segmentAD(ber.header)); err != nil {
+			return nil, 0, 0, fmt.Errorf("segment %d offset %d: %w", ber.segmentID, ber.offset, err)
+		}
+	}
+	if compression := Compression(fh.flags & frameCompressionMask); compression != CompressionNone {
+		codec, err := lookupCodec(compression)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		if data, err = codec.Decompress(data, ber.maxRecordSize); err != nil {
+			return nil, 0, 0, ber.corruption(fmt.Errorf("%w: decompress %v: %v", ErrCorruptEntry, compression, err))
+		}
+	}
+
+	return data, fh.flags, size, nil
+}
+
+// cipher returns the AEAD decrypting the records of the segment
+func (ber *BinaryEntryReader) cipher() (cipher.AEAD, error) {
+	if ber.aead != nil {
+		return ber.aead, nil
+	}
+
+	aead, err := newSegmentCipher(ber.keys, ber.header)
+	if err != nil {
+		return nil, err
+	}
+	if aead == nil {
+		return nil, fmt.Errorf("%w: encrypted record in segment %d without encryption flag", ErrDecryptionFailed, ber.segmentID)
+	}
+
+	ber.aead = aead
+	return aead, nil
+}
+
+// checkRecordSize rejects a record length above the maximum record size
+func (ber *BinaryEntryReader) checkRecordSize(size uint32) error {
+	if int64(size) > int64(ber.maxRecordSize) {
+		return ber.corruption(fmt.Errorf("%w: record size %d exceeds maximum of %d", ErrCorruptEntry, size, ber.maxRecordSize))
+	}
+	return nil
+}
+
+// readPayload reads a record of the given size
+func (ber *BinaryEntryReader) readPayload(size uint32) ([]byte, error) {
 	data := make([]byte, size)
 	if _, err := io.ReadFull(ber.br, data); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, ber.corruption(fmt.Errorf("%w: read entry data: %v", ErrTruncatedEntry, err))
+		}
 		return nil, fmt.Errorf("read entry data: %w", err)
 	}
+	return data, nil
+}
 
-	// Unmarshal entry
-	var entry WAL_Entry
-	MustUnmarshal(data, &entry)
+// Header returns the segment header, or nil if the stream has no header.
+//
+// The header is read on first use. An incomplete header yields a *CorruptionError
+// wrapping ErrTruncatedEntry and an invalid one an error wrapping
+// ErrInvalidSegmentHeader or ErrUnsupportedFormat.
+func (ber *BinaryEntryReader) Header() (*SegmentHeader, error) {
+	if ber.headerRead {
+		return ber.header, nil
+	}
+	if ber.offset > 0 {
+		// Positioned past the start, there is no header to read
+		ber.headerRead = true
+		return nil, nil
+	}
 
-	return &entry, nil
+	magic, err := ber.br.Peek(len(segmentMagic))
+	if err != nil || !bytes.Equal(magic, segmentMagic[:]) {
+		// Empty, legacy, or too short to tell, entry reads
+		// report anything short as a truncated entry
+		ber.headerRead = true
+		return nil, nil
+	}
+
+	buf := make([]byte, SegmentHeaderSize)
+	if _, err := io.ReadFull(ber.br, buf); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, ber.corruption(fmt.Errorf("%w: read header: %v", ErrTruncatedEntry, err))
+		}
+		return nil, fmt.Errorf("read header: %w", err)
+	}
+
+	var header SegmentHeader
+	if err := header.UnmarshalBinary(buf); err != nil {
+		return nil, ber.corruption(err)
+	}
+
+	ber.header = &header
+	ber.headerRead = true
+	ber.offset = SegmentHeaderSize
+	return ber.header, nil
+}
+
+// seek positions the reader at the given offset, which must be the start of
+// an entry at or after the current offset.
+//
+// The header is read first so that it stays available after seeking.
+func (ber *BinaryEntryReader) seek(offset int64) error {
+	if _, err := ber.Header(); err != nil {
+		return err
+	}
+	if offset <= ber.offset {
+		return nil
+	}
+
+	if seeker, ok := ber.r.(io.Seeker); ok {
+		if _, err := seeker.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+		ber.br.Reset(ber.r)
+	} else if _, err := ber.br.Discard(int(offset - ber.offset)); err != nil {
+		return err
+	}
+
+	ber.offset = offset
+	return nil
+}
+
+// ReadVerifiedEntry reads the next WAL entry and verifies its CRC checksum.
+//
+// The checksum algorithm is taken from the segment header.
+//
+// A checksum failure yields a *CorruptionError wrapping ErrCRCMismatch.
+func (ber *BinaryEntryReader) ReadVerifiedEntry() (*WAL_Entry, error) {
+	entry, err := ber.ReadEntry()
+	if err != nil {
+		return nil, err
+	}
+
+	// Verify CRC at application level, not transport level
+	if err := VerifyEntryWith(entry, segmentChecksum(ber.header)); err != nil {
+		// The rest of a batch is discarded along with it
+		ber.offset = ber.entryOffset
+		ber.pending = nil
+		return nil, ber.corruption(err)
+	}
+
+	return entry, nil
+}
+
+// inBatch reports whether entries of the batch being read remain
+func (ber *BinaryEntryReader) inBatch() bool {
+	return len(ber.pending) > 0
+}
+
+// corruption wraps err with the location of the entry being read
+func (ber *BinaryEntryReader) corruption(err error) error {
+	return &CorruptionError{
+		SegmentID: ber.segmentID,
+		Offset:    ber.offset,
+		Err:       err,
+	}
+}
+
+// Offset returns the number of bytes consumed by the entries read so far.
+//
+// After ReadEntry fails, Offset is the position where the failed entry starts,
+// which is the end of the last valid entry.
+func (ber *BinaryEntryReader) Offset() int64 {
+	return ber.offset
 }
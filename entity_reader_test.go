@@ -0,0 +1,86 @@
+package wal
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// encodeSegment returns a segment with the given header holding entries
+func encodeSegment(t *testing.T, header *SegmentHeader, entries ...*WAL_Entry) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	entryWriter := NewBinaryEntryWriter(&buf)
+	if err := entryWriter.WriteHeader(header); err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if err := entryWriter.WriteEntry(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := entryWriter.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// checkCorruption checks that err is a *CorruptionError at offset wrapping kind
+func checkCorruption(t *testing.T, err error, offset int64, kind error) {
+	t.Helper()
+
+	var corruption *CorruptionError
+	if !errors.As(err, &corruption) || !errors.Is(err, kind) {
+		t.Fatalf("error = %v, want a CorruptionError wrapping %v", err, kind)
+	}
+	if corruption.Offset != offset {
+		t.Fatalf("corruption at offset %d, want %d", corruption.Offset, offset)
+	}
+}
+
+func TestReadEntryReportsUndecodableRecord(t *testing.T) {
+	var buf bytes.Buffer
+	entryWriter := NewBinaryEntryWriter(&buf)
+	if err := entryWriter.WriteHeader(newSegmentHeader(1, ChecksumCRC32C)); err != nil {
+		t.Fatal(err)
+	}
+	// A frame with a valid checksum around a payload that is not an entry
+	if err := entryWriter.writeRecord([]byte{0xff}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := entryWriter.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := NewBinaryEntryReader(&buf).ReadEntry()
+	checkCorruption(t, err, SegmentHeaderSize, ErrCorruptEntry)
+}
+
+func TestReadVerifiedEntryReportsCRCMismatch(t *testing.T) {
+	first := NewEntryWithChecksum(1, []byte("a"), ChecksumCRC32C)
+	second := NewEntryWithChecksum(2, []byte("b"), ChecksumCRC32C)
+	second.CRC++
+	data := encodeSegment(t, newSegmentHeader(1, ChecksumCRC32C), first, second)
+
+	entries, err := ReadAllEntries(bytes.NewReader(data))
+	if len(entries) != 1 {
+		t.Fatalf("ReadAllEntries() returned %d entries, want the first one", len(entries))
+	}
+	frameSize := int64(len(data)-SegmentHeaderSize) / 2
+	checkCorruption(t, err, SegmentHeaderSize+frameSize, ErrCRCMismatch)
+}
+
+func TestReadEntryReportsTruncatedRecord(t *testing.T) {
+	data := encodeSegment(t, newSegmentHeader(1, ChecksumCRC32C), NewEntryWithChecksum(1, []byte("abc"), ChecksumCRC32C))
+
+	_, err := NewBinaryEntryReader(bytes.NewReader(data[:len(data)-2])).ReadEntry()
+	checkCorruption(t, err, SegmentHeaderSize, ErrTruncatedEntry)
+}
+
+func TestUnmarshalReturnsError(t *testing.T) {
+	var entry WAL_Entry
+	if err := Unmarshal([]byte{0xff}, &entry); err == nil {
+		t.Fatal("Unmarshal() succeeded on invalid data")
+	}
+}
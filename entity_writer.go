@@ -2,6 +2,7 @@ package wal
 
 import (
 	"bufio"
+	"crypto/cipher"
 	"encoding/binary"
 	"fmt"
 	"io"
@@ -22,6 +23,45 @@ type EntryWriter interface {
 	Sync() error
 }
 
+// SyncPolicy controls how flushed data is persisted to disk.
+type SyncPolicy int
+
+const (
+	// SyncPolicyDefault uses SyncPolicyFsync when WALOptions.EnableFsync
+	// is set and SyncPolicyNone otherwise.
+	SyncPolicyDefault SyncPolicy = iota
+	// SyncPolicyFsync syncs file data and metadata with fsync.
+	SyncPolicyFsync
+	// SyncPolicyFdatasync syncs file data with fdatasync, skipping metadata
+	// that is not needed to read the data back. Falls back to fsync on
+	// platforms without fdatasync.
+	SyncPolicyFdatasync
+	// SyncPolicyNone only flushes data to the operating system and never syncs.
+	// Data survives a process crash but not a machine crash.
+	SyncPolicyNone
+	// SyncPolicyOnRotate only syncs a segment when it is sealed by rotation.
+	// Sync, Close and checkpoints only flush.
+	SyncPolicyOnRotate
+)
+
+// String returns the name of the sync policy
+func (p SyncPolicy) String() string {
+	switch p {
+	case SyncPolicyDefault:
+		return "default"
+	case SyncPolicyFsync:
+		return "fsync"
+	case SyncPolicyFdatasync:
+		return "fdatasync"
+	case SyncPolicyNone:
+		return "none"
+	case SyncPolicyOnRotate:
+		return "on-rotate"
+	default:
+		return fmt.Sprintf("SyncPolicy(%d)", int(p))
+	}
+}
+
 // syncer is a helper interface for types that support Sync
 type syncer interface {
 	Sync() error
@@ -29,7 +69,14 @@ type syncer interface {
 
 // BinaryEntryWriter writes entries in binary format with a length prefix.
 //
-// The binary format consists of:
+// Once a SegmentHeader of format version 2 or later is written, each entry is
+// stored in a checksummed, optionally compressed, frame:
+//   - 4 bytes: uint32 length of the protobuf-encoded entry (little-endian)
+//   - 4 bytes: uint32 frame flags (little-endian)
+//   - 4 bytes: uint32 checksum of the length, flags and entry (little-endian)
+//   - N bytes: protobuf-encoded WAL_Entry
+//
+// Without a header, entries are written in the legacy format:
 //   - 4 bytes: uint32 length of the protobuf-encoded entry (little-endian)
 //   - N bytes: protobuf-encoded WAL_Entry
 //
@@ -42,6 +89,22 @@ type BinaryEntryWriter struct {
 	bw *bufio.Writer
 	// syncWriter is only used if the writer supports Sync
 	syncWriter syncer
+	// syncPolicy is how Sync persists data
+	syncPolicy SyncPolicy
+	// header is the header of the segment being written
+	// it selects the record framing, nil for legacy segments
+	header *SegmentHeader
+	// maxRecordSize is the largest record accepted
+	maxRecordSize int
+	// compression is the codec ID recorded in compressed frames
+	compression Compression
+	// codec compresses records, nil when compression is disabled
+	codec Codec
+	// keys supplies the keys of encrypted segments
+	keys KeyProvider
+	// aead encrypts records of an encrypted segment
+	// it is resolved from keys when the header is set
+	aead cipher.AEAD
 }
 
 // NewBinaryEntryWriter creates a new BinaryEntryWriter that writes to w.
@@ -52,18 +115,76 @@ func NewBinaryEntryWriter(w io.Writer) *BinaryEntryWriter {
 	bw := bufio.NewWriterSize(w, defaultBufferSize)
 	syncWriter, _ := w.(syncer)
 	return &BinaryEntryWriter{
-		w:          w,
-		bw:         bw,
-		syncWriter: syncWriter,
+		w:             w,
+		bw:            bw,
+		syncWriter:    syncWriter,
+		syncPolicy:    SyncPolicyFsync,
+		maxRecordSize: defaultMaxRecordSize,
+	}
+}
+
+// SetMaxRecordSize sets the largest encoded entry the writer accepts, in bytes.
+//
+// Larger entries are rejected with ErrRecordTooLarge. The default is 64MB.
+func (bew *BinaryEntryWriter) SetMaxRecordSize(size int) {
+	bew.maxRecordSize = size
+}
+
+// SetCompression sets the codec used to compress entries.
+//
+// Compression only applies to framed segments, and an entry is stored
+// uncompressed when compressing does not make it smaller. The default is
+// CompressionNone. Returns an error wrapping ErrUnknownCodec if no codec is
+// registered for the compression.
+func (bew *BinaryEntryWriter) SetCompression(compression Compression) error {
+	if compression == CompressionNone {
+		bew.compression, bew.codec = CompressionNone, nil
+		return nil
+	}
+
+	codec, err := lookupCodec(compression)
+	if err != nil {
+		return err
 	}
+	bew.compression, bew.codec = compression, codec
+	return nil
+}
+
+// SetKeyProvider sets the provider of the keys used to encrypt segments.
+//
+// It must be set before writing the header of an encrypted segment.
+func (bew *BinaryEntryWriter) SetKeyProvider(keys KeyProvider) {
+	bew.keys = keys
+}
+
+// SetSyncPolicy sets how Sync persists flushed data.
+//
+// The default policy is SyncPolicyFsync.
+func (bew *BinaryEntryWriter) SetSyncPolicy(policy SyncPolicy) {
+	bew.syncPolicy = policy
 }
 
 // WriteEntry writes a WAL entry in binary format.
 //
-// The entry is marshaled to protobuf, prefixed with its length as a 4-byte
-// little-endian uint32, and written to the buffered writer.
+// The entry is marshaled to protobuf and written to the buffered writer, either
+// in a checksummed frame or prefixed with its length as a 4-byte little-endian
+// uint32, depending on the segment format.
+//
+// Returns an error wrapping ErrRecordTooLarge if the encoded entry exceeds the
+// maximum record size.
 func (bew *BinaryEntryWriter) WriteEntry(entry *WAL_Entry) error {
-	data := MustMarshal(entry)
+	data, err := Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal entry: %w", err)
+	}
+
+	if len(data) > bew.maxRecordSize {
+		return fmt.Errorf("%w: %d bytes exceeds maximum of %d", ErrRecordTooLarge, len(data), bew.maxRecordSize)
+	}
+
+	if usesFrames(bew.header) {
+		return bew.writeRecord(data, 0)
+	}
 
 	// Write length prefix
 	size := uint32(len(data))
@@ -79,6 +200,121 @@ func (bew *BinaryEntryWriter) WriteEntry(entry *WAL_Entry) error {
 	return nil
 }
 
+// WriteBatch writes several entries atomically in a single frame.
+//
+// The frame checksum covers every entry, so a partially written batch is
+// discarded as a whole on recovery. The batch is compressed and encrypted as
+// one record. Batches require a segment of format version 2 or later and
+// return an error wrapping ErrUnsupportedFormat otherwise.
+//
+// Returns an error wrapping ErrRecordTooLarge if the encoded batch exceeds the
+// maximum record size.
+func (bew *BinaryEntryWriter) WriteBatch(entries []*WAL_Entry) error {
+	if !usesFrames(bew.header) {
+		return fmt.Errorf("%w: batches require segment format version %d", ErrUnsupportedFormat, SegmentFormatVersion)
+	}
+
+	data, err := encodeBatch(entries)
+	if err != nil {
+		return err
+	}
+
+	if len(data) > bew.maxRecordSize {
+		return fmt.Errorf("%w: batch of %d bytes exceeds maximum of %d", ErrRecordTooLarge, len(data), bew.maxRecordSize)
+	}
+
+	return bew.writeRecord(data, frameFlagBatch)
+}
+
+// writeRecord compresses and encrypts a record as configured
+// and writes it in a checksummed frame
+func (bew *BinaryEntryWriter) writeRecord(data []byte, flags uint32) error {
+	data, compression, err := bew.compress(data)
+	if err != nil {
+		return err
+	}
+	flags |= compression
+
+	if bew.aead != nil {
+		if data, err = encrypt(bew.aead, data, segmentAD(bew.header)); err != nil {
+			return fmt.Errorf("failed to encrypt entry: %w", err)
+		}
+		flags |= frameFlagEncrypted
+	}
+
+	return bew.writeFrame(data, flags)
+}
+
+// compress compresses a record with the configured codec
+// it returns the record to store and the frame flags describing it
+func (bew *BinaryEntryWriter) compress(data []byte) ([]byte, uint32, error) {
+	if bew.codec == nil || len(data) < compressionMinSize {
+		return data, 0, nil
+	}
+
+	compressed, err := bew.codec.Compress(data)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to compress entry: %w", err)
+	}
+	if len(compressed) >= len(data) {
+		return data, 0, nil
+	}
+	return compressed, uint32(bew.compression), nil
+}
+
+// writeFrame writes a record in a checksummed frame
+func (bew *BinaryEntryWriter) writeFrame(data []byte, flags uint32) error {
+	fh := newFrameHeader(segmentChecksum(bew.header), flags, data)
+
+	var buf [frameHeaderSize]byte
+	fh.encode(buf[:])
+	if _, err := bew.bw.Write(buf[:]); err != nil {
+		return fmt.Errorf("failed to write frame header: %w", err)
+	}
+
+	// Write entry data
+	if _, err := bew.bw.Write(data); err != nil {
+		return fmt.Errorf("failed to write entry: %w", err)
+	}
+
+	return nil
+}
+
+// WriteHeader writes a segment header.
+//
+// The header must be written before any entry, at the start of the segment.
+// Entries written afterwards use the record framing of the header's format version,
+// and are encrypted if the header has SegmentFlagEncrypted set.
+func (bew *BinaryEntryWriter) WriteHeader(header *SegmentHeader) error {
+	data, err := header.MarshalBinary()
+	if err != nil {
+		return fmt.Errorf("failed to marshal header: %w", err)
+	}
+
+	if err := bew.setHeader(header); err != nil {
+		return err
+	}
+
+	if _, err := bew.bw.Write(data); err != nil {
+		return fmt.Errorf("failed to write header: %w", err)
+	}
+
+	return nil
+}
+
+// setHeader sets the header of the segment being written
+// without writing it, used to append to an existing segment
+func (bew *BinaryEntryWriter) setHeader(header *SegmentHeader) error {
+	aead, err := newSegmentCipher(bew.keys, header)
+	if err != nil {
+		return err
+	}
+
+	bew.header = header
+	bew.aead = aead
+	return nil
+}
+
 // Flush flushes any buffered data to the underlying writer.
 //
 // This writes all buffered data but does not guarantee persistence to disk.
@@ -92,17 +328,43 @@ func (bew *BinaryEntryWriter) Flush() error {
 
 // Sync flushes buffered data and syncs to disk if the underlying writer supports it.
 //
-// For writers like *os.File, Sync calls fsync to ensure data is persisted.
-// If the underlying writer does not support Sync, only the flush is performed.
+// For writers like *os.File, Sync calls fsync or fdatasync depending on the
+// sync policy. If the underlying writer does not support Sync, or the policy
+// is SyncPolicyNone or SyncPolicyOnRotate, only the flush is performed.
 func (bew *BinaryEntryWriter) Sync() error {
 	if err := bew.Flush(); err != nil {
 		return err
 	}
 
-	if bew.syncWriter != nil {
-		if err := bew.syncWriter.Sync(); err != nil {
-			return fmt.Errorf("sync: %w", err)
-		}
+	return bew.syncFile()
+}
+
+// syncFile syncs already flushed data to disk without touching the buffer.
+//
+// It is safe to call concurrently with WriteEntry, which allows a group commit
+// to sync while other writers keep appending to the buffer.
+func (bew *BinaryEntryWriter) syncFile() error {
+	return bew.syncFileWith(bew.syncPolicy)
+}
+
+// syncFileWith syncs already flushed data to disk using the given policy.
+func (bew *BinaryEntryWriter) syncFileWith(policy SyncPolicy) error {
+	if bew.syncWriter == nil {
+		return nil
+	}
+
+	var err error
+	switch policy {
+	case SyncPolicyNone, SyncPolicyOnRotate:
+		return nil
+	case SyncPolicyFdatasync:
+		err = fdatasync(bew.syncWriter)
+	default:
+		err = bew.syncWriter.Sync()
+	}
+
+	if err != nil {
+		return fmt.Errorf("sync: %w", err)
 	}
 	return nil
 }
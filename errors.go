@@ -0,0 +1,88 @@
+package wal
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrClosed is returned when waiting on a WAL that has been closed
+	ErrClosed = errors.New("wal is closed")
+	// ErrSyncFailed is returned by every write and sync after a segment failed
+	// to sync, until the WAL is reopened
+	ErrSyncFailed = errors.New("sync failed")
+	// ErrEntryNotFound is returned when no entry has the requested LSN
+	ErrEntryNotFound = errors.New("entry not found")
+	// ErrRetentionBlocked is reported when segments the retention policy would delete
+	// are kept because they are needed to recover from the latest checkpoint
+	ErrRetentionBlocked = errors.New("retention blocked")
+	// ErrTxnDone is returned when using a transaction that was committed or aborted
+	ErrTxnDone = errors.New("transaction already committed or aborted")
+	// ErrLSNOutOfRange is returned when an LSN lies outside the entries held by the WAL
+	ErrLSNOutOfRange = errors.New("LSN out of range")
+	// ErrStaleTerm is returned when writing on behalf of a term older than the current term
+	ErrStaleTerm = errors.New("stale term")
+	// ErrBatchSplit is returned when truncating between entries written by the same batch
+	ErrBatchSplit = errors.New("cannot split a batch")
+	// ErrLocked is returned when the directory of a FileSegmentManager is locked by another writer
+	ErrLocked = errors.New("directory locked by another writer")
+
+	// ErrCorruptEntry is returned when an entry cannot be decoded
+	ErrCorruptEntry = errors.New("corrupt entry")
+	// ErrCRCMismatch is returned when an entry fails checksum verification
+	ErrCRCMismatch = errors.New("CRC mismatch")
+	// ErrTruncatedEntry is returned when an entry ends before its declared length
+	ErrTruncatedEntry = errors.New("truncated entry")
+	// ErrRecordTooLarge is returned when an entry exceeds the maximum record size
+	ErrRecordTooLarge = errors.New("record too large")
+
+	// ErrInvalidSegmentHeader is returned when a segment header fails validation
+	ErrInvalidSegmentHeader = errors.New("invalid segment header")
+	// ErrUnsupportedFormat is returned when a segment uses a newer format version
+	ErrUnsupportedFormat = errors.New("unsupported segment format")
+	// ErrNoSegmentHeader is returned when a segment predates segment headers
+	ErrNoSegmentHeader = errors.New("no segment header")
+	// ErrUnknownCodec is returned when a record uses an unregistered compression codec
+	ErrUnknownCodec = errors.New("unknown compression codec")
+
+	// ErrKeyNotFound is returned when the key a segment is encrypted with is unavailable
+	ErrKeyNotFound = errors.New("encryption key not found")
+	// ErrDecryptionFailed is returned when an encrypted record fails authentication,
+	// which means the wrong key was supplied or the record was tampered with
+	ErrDecryptionFailed = errors.New("decryption failed")
+)
+
+// CorruptionError reports a damaged entry along with where it was found.
+//
+// CorruptionError wraps one of ErrCorruptEntry, ErrCRCMismatch or ErrTruncatedEntry,
+// so callers can match on the kind of corruption with errors.Is and recover the
+// location with errors.As.
+type CorruptionError struct {
+	// SegmentID is the segment containing the entry
+	// it is -1 when the entry was not read from a segment
+	SegmentID int
+	// Offset is the byte offset where the entry starts
+	Offset int64
+	// Err is the underlying error
+	Err error
+}
+
+// Error returns the error message including the location of the entry
+func (e *CorruptionError) Error() string {
+	if e.SegmentID < 0 {
+		return fmt.Sprintf("offset %d: %v", e.Offset, e.Err)
+	}
+	return fmt.Sprintf("segment %d offset %d: %v", e.SegmentID, e.Offset, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *CorruptionError) Unwrap() error {
+	return e.Err
+}
+
+// isCorruption reports whether err is caused by a damaged entry
+func isCorruption(err error) bool {
+	return errors.Is(err, ErrCorruptEntry) ||
+		errors.Is(err, ErrCRCMismatch) ||
+		errors.Is(err, ErrTruncatedEntry)
+}
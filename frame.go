@@ -0,0 +1,110 @@
+package wal
+
+import "encoding/binary"
+
+const (
+	// frameHeaderSize is the size in bytes of a frame header
+	frameHeaderSize = 16
+
+	// legacyFrameVersion is the last segment format version
+	// that uses plain length-prefixed records
+	legacyFrameVersion uint16 = 1
+
+	// frameCompressionMask selects the compression ID in the frame flags
+	frameCompressionMask uint32 = 0xff
+)
+
+var defaultMaxRecordSize = 64 * 1024 * 1024 // 64MB
+
+// frameHeader is the header preceding every record in segments
+// of format version 2 and later.
+//
+// The binary layout is 16 bytes, little-endian:
+//   - 4 bytes: length of the record payload
+//   - 4 bytes: flags describing the payload encoding
+//   - 4 bytes: checksum of the length and flags
+//   - 4 bytes: checksum of the length, flags and payload
+//
+// The low 8 bits of the flags hold the Compression of the payload,
+// frameFlagEncrypted marks a payload encrypted after compression and
+// frameFlagBatch marks a payload holding several entries. Replication streams
+// also carry empty frames marked with frameFlagHeartbeat, which never appear
+// in segments.
+//
+// The header checksum is verified before the length is trusted, so a
+// corrupted length prefix is reported as corruption without allocating or
+// reading the payload, and is never mistaken for a record running past the
+// end of the segment. The frame checksum covers the whole encoded record.
+type frameHeader struct {
+	// length is the length of the payload in bytes
+	length uint32
+	// flags describe the payload encoding
+	flags uint32
+	// headerChecksum covers the length and flags
+	headerChecksum uint32
+	// checksum covers the length, flags and payload
+	checksum uint32
+}
+
+// newFrameHeader creates the header of a frame holding payload
+func newFrameHeader(algo ChecksumAlgorithm, flags uint32, payload []byte) frameHeader {
+	length := uint32(len(payload))
+	return frameHeader{
+		length:         length,
+		flags:          flags,
+		headerChecksum: frameHeaderChecksum(algo, length, flags),
+		checksum:       frameChecksum(algo, length, flags, payload),
+	}
+}
+
+// encode writes the frame header into buf
+func (fh *frameHeader) encode(buf []byte) {
+	binary.LittleEndian.PutUint32(buf[0:4], fh.length)
+	binary.LittleEndian.PutUint32(buf[4:8], fh.flags)
+	binary.LittleEndian.PutUint32(buf[8:12], fh.headerChecksum)
+	binary.LittleEndian.PutUint32(buf[12:16], fh.checksum)
+}
+
+// decodeFrameHeader reads a frame header from buf
+func decodeFrameHeader(buf []byte) frameHeader {
+	return frameHeader{
+		length:         binary.LittleEndian.Uint32(buf[0:4]),
+		flags:          binary.LittleEndian.Uint32(buf[4:8]),
+		headerChecksum: binary.LittleEndian.Uint32(buf[8:12]),
+		checksum:       binary.LittleEndian.Uint32(buf[12:16]),
+	}
+}
+
+// frameHeaderChecksum computes the checksum of a frame header
+// over its length and flags
+func frameHeaderChecksum(algo ChecksumAlgorithm, length, flags uint32) uint32 {
+	return frameChecksum(algo, length, flags, nil)
+}
+
+// frameChecksum computes the checksum of a frame
+// over its length, flags and payload
+func frameChecksum(algo ChecksumAlgorithm, length, flags uint32, payload []byte) uint32 {
+	var buf [8]byte
+	binary.LittleEndian.PutUint32(buf[0:4], length)
+	binary.LittleEndian.PutUint32(buf[4:8], flags)
+
+	h := algo.newHash()
+	h.Write(buf[:])
+	h.Write(payload)
+	return h.Sum32()
+}
+
+// segmentChecksum returns the checksum algorithm of a segment
+// header-less legacy segments use ChecksumIEEE
+func segmentChecksum(header *SegmentHeader) ChecksumAlgorithm {
+	if header == nil {
+		return ChecksumIEEE
+	}
+	return header.Checksum
+}
+
+// usesFrames reports whether a segment with the given header
+// stores records in checksummed frames
+func usesFrames(header *SegmentHeader) bool {
+	return header != nil && header.Version > legacyFrameVersion
+}
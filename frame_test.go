@@ -0,0 +1,93 @@
+package wal
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestRecordTooLargeKeepsLSN(t *testing.T) {
+	opts := testOptions()
+	opts.MaxRecordSize = 100
+	w := openTestWAL(t, t.TempDir(), opts)
+
+	if _, err := w.WriteEntry(bytes.Repeat([]byte("x"), 200)); !errors.Is(err, ErrRecordTooLarge) {
+		t.Fatalf("WriteEntry() error = %v, want ErrRecordTooLarge", err)
+	}
+	if got := w.LastLSN(); got != 0 {
+		t.Fatalf("LastLSN() = %d, want 0", got)
+	}
+
+	lsn, err := w.WriteEntry([]byte("small"))
+	if err != nil || lsn != 1 {
+		t.Fatalf("WriteEntry() = %d, %v, want 1", lsn, err)
+	}
+	if err := w.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	entry, err := w.Get(1)
+	if err != nil || string(entry.Data) != "small" {
+		t.Fatalf("Get(1) = %v, %v", entry, err)
+	}
+}
+
+// frameSegment returns a segment holding two entries
+// and the offset of the second frame
+func frameSegment(t *testing.T) ([]byte, int64) {
+	t.Helper()
+
+	first := NewEntryWithChecksum(1, []byte("first"), ChecksumCRC32C)
+	second := NewEntryWithChecksum(2, []byte("second"), ChecksumCRC32C)
+	data := encodeSegment(t, newSegmentHeader(1, ChecksumCRC32C), first, second)
+	single := encodeSegment(t, newSegmentHeader(1, ChecksumCRC32C), first)
+	return data, int64(len(single))
+}
+
+func TestFrameChecksumCoversHeader(t *testing.T) {
+	tests := []struct {
+		name string
+		// offset is the byte to damage in the frame header
+		offset int64
+	}{
+		{"length", 0},
+		{"flags", 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, _ := frameSegment(t)
+			data[SegmentHeaderSize+tt.offset] ^= 0x01
+
+			_, err := ReadAllEntries(bytes.NewReader(data))
+			checkCorruption(t, err, SegmentHeaderSize, ErrCRCMismatch)
+		})
+	}
+}
+
+func TestFrameLengthIsValidatedBeforeReading(t *testing.T) {
+	tests := []struct {
+		name string
+		// headerChecksum is whether the header checksum is updated
+		headerChecksum bool
+		want           error
+	}{
+		{"corrupted", false, ErrCRCMismatch},
+		{"above maximum", true, ErrCorruptEntry},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, second := frameSegment(t)
+			fh := decodeFrameHeader(data[second:])
+			fh.length = 1 << 31
+			if tt.headerChecksum {
+				fh.headerChecksum = frameHeaderChecksum(ChecksumCRC32C, fh.length, fh.flags)
+			}
+			fh.encode(data[second:])
+
+			entries, err := ReadAllEntries(bytes.NewReader(data))
+			if len(entries) != 1 {
+				t.Fatalf("ReadAllEntries() returned %d entries, want the first one", len(entries))
+			}
+			checkCorruption(t, err, second, tt.want)
+		})
+	}
+}
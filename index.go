@@ -0,0 +1,215 @@
+package wal
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	sync "sync"
+)
+
+var defaultIndexInterval int64 = 1024 * 4 // 4KB
+
+// lsnIndex maps LSNs to segments and byte offsets within them.
+//
+// The index is sparse: it records the offset of one entry every indexInterval
+// bytes, so a lookup reads at most indexInterval bytes past the indexed entry.
+// It is built lazily from segment contents the first time a segment is searched
+// and extended incrementally as the active segment grows.
+type lsnIndex struct {
+	// source opens the segments to index
+	source *segmentSource
+	// interval is the number of bytes between index points
+	interval int64
+
+	// mu is the mutex for the index
+	// it is used to protect the segments
+	mu sync.Mutex
+	// segments are the per-segment indexes
+	segments map[int]*segmentIndex
+}
+
+// segmentIndex is the sparse index for a single segment
+type segmentIndex struct {
+	// header is the segment header
+	// it is nil for legacy segments
+	header *SegmentHeader
+	// headerRead is whether the header was read
+	headerRead bool
+	// points are the indexed entries in ascending LSN order
+	points []indexPoint
+	// scanned is the offset up to which the segment was indexed
+	scanned int64
+	// lastLSN is the last LSN indexed
+	lastLSN uint64
+}
+
+// indexPoint records where an entry starts
+type indexPoint struct {
+	// lsn is the LSN of the entry
+	lsn uint64
+	// offset is the byte offset where the entry starts
+	offset int64
+}
+
+// newLSNIndex creates an empty index for the segment manager
+func newLSNIndex(source *segmentSource) *lsnIndex {
+	return &lsnIndex{
+		source:   source,
+		interval: defaultIndexInterval,
+		segments: make(map[int]*segmentIndex),
+	}
+}
+
+// locate finds where to start reading to find lsn
+// it returns the position in segments of the segment to start from
+// and the byte offset in that segment of an entry at or before lsn
+func (x *lsnIndex) locate(segments []int, lsn uint64) (int, int64, error) {
+	if len(segments) == 0 || lsn == 0 {
+		return 0, 0, nil
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	// Find the last segment starting at or before lsn. Empty segments
+	// are treated as starting after lsn, which can only move the search
+	// to an earlier segment and never skips entries
+	start := 0
+	lo, hi := 0, len(segments)-1
+	for lo <= hi {
+		mid := (lo + hi) / 2
+		base, ok, err := x.baseLSN(segments[mid])
+		if err != nil {
+			return 0, 0, err
+		}
+		if ok && base <= lsn {
+			start = mid
+			lo = mid + 1
+		} else {
+			hi = mid - 1
+		}
+	}
+
+	offset, err := x.offset(segments[start], lsn)
+	if err != nil {
+		return 0, 0, err
+	}
+	return start, offset, nil
+}
+
+// describe returns the first LSN and the header of the segment
+// ok is false if a legacy segment has no entries yet
+func (x *lsnIndex) describe(id int) (uint64, bool, *SegmentHeader, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	base, ok, err := x.baseLSN(id)
+	if err != nil {
+		return 0, false, nil, err
+	}
+	return base, ok, x.segment(id).header, nil
+}
+
+// baseLSN returns the first LSN of the segment
+// it is read from the segment header when there is one
+// ok is false if a legacy segment has no entries yet
+func (x *lsnIndex) baseLSN(id int) (uint64, bool, error) {
+	si := x.segment(id)
+	if !si.headerRead {
+		if err := x.scan(id, si, 0); err != nil {
+			return 0, false, err
+		}
+	}
+	if si.header != nil {
+		return si.header.BaseLSN, true, nil
+	}
+	if len(si.points) == 0 {
+		if err := x.scan(id, si, 0); err != nil {
+			return 0, false, err
+		}
+	}
+	if len(si.points) == 0 {
+		return 0, false, nil
+	}
+	return si.points[0].lsn, true, nil
+}
+
+// offset returns the offset of the last indexed entry at or before lsn
+func (x *lsnIndex) offset(id int, lsn uint64) (int64, error) {
+	si := x.segment(id)
+	if si.lastLSN < lsn {
+		if err := x.scan(id, si, lsn); err != nil {
+			return 0, err
+		}
+	}
+
+	i := sort.Search(len(si.points), func(i int) bool {
+		return si.points[i].lsn > lsn
+	}) - 1
+	if i < 0 {
+		return 0, nil
+	}
+	return si.points[i].offset, nil
+}
+
+// segment returns the index for the segment, creating it if needed
+func (x *lsnIndex) segment(id int) *segmentIndex {
+	si, ok := x.segments[id]
+	if !ok {
+		si = &segmentIndex{}
+		x.segments[id] = si
+	}
+	return si
+}
+
+// scan extends the segment index from where it was last scanned
+// until an entry at or after lsn is found or no complete entries remain
+func (x *lsnIndex) scan(id int, si *segmentIndex, lsn uint64) error {
+	reader, entryReader, err := x.source.openAt(id, si.scanned)
+	if isCorruption(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	if !si.headerRead {
+		si.header = entryReader.header
+		si.headerRead = true
+	}
+
+	for {
+		entry, err := entryReader.ReadEntry()
+		if err == io.EOF || isCorruption(err) {
+			// The rest of the segment is either still being
+			// written or corrupt, readers will find out which
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("index segment %d: %w", id, err)
+		}
+
+		offset := entryReader.entryOffset
+		if len(si.points) == 0 || offset-si.points[len(si.points)-1].offset >= x.interval {
+			si.points = append(si.points, indexPoint{
+				lsn:    entry.LogSequenceNumber,
+				offset: offset,
+			})
+		}
+		si.lastLSN = entry.LogSequenceNumber
+		si.scanned = entryReader.Offset()
+
+		if entry.LogSequenceNumber >= lsn {
+			return nil
+		}
+	}
+}
+
+// forget drops the index for a segment that was deleted or rewritten
+func (x *lsnIndex) forget(id int) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	delete(x.segments, id)
+}
@@ -0,0 +1,115 @@
+package wal
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestIndexLocate(t *testing.T) {
+	w := openTestWAL(t, t.TempDir(), testOptions())
+	for i := range 2000 {
+		if _, err := w.WriteEntry(bytes.Repeat([]byte{byte(i)}, 100)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	segments, err := w.segmentMgr.ListSegments()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, lsn := range []uint64{1, 2, 500, 1234, 2000} {
+		start, offset, err := w.index.locate(segments, lsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Reading from the offset reaches lsn within the interval
+		reader, entryReader, err := w.source.openAt(segments[start], offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for {
+			entry, err := entryReader.ReadVerifiedEntry()
+			if err != nil {
+				t.Fatalf("LSN %d: %v", lsn, err)
+			}
+			if entry.LogSequenceNumber > lsn {
+				t.Fatalf("LSN %d: located offset %d is past the entry", lsn, offset)
+			}
+			if entry.LogSequenceNumber == lsn {
+				if skipped := entryReader.entryOffset - offset; skipped > w.index.interval {
+					t.Fatalf("LSN %d: read %d bytes past the located offset", lsn, skipped)
+				}
+				break
+			}
+		}
+		reader.Close()
+	}
+}
+
+func TestIndexLocateAcrossSegments(t *testing.T) {
+	w := openTestWAL(t, t.TempDir(), truncateOptions())
+	writeEntries(t, w, 100)
+	if err := w.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	segments, err := w.segmentMgr.ListSegments()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for lsn := uint64(1); lsn <= 100; lsn++ {
+		start, _, err := w.index.locate(segments, lsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		base, _, _, err := w.index.describe(segments[start])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if base > lsn {
+			t.Fatalf("LSN %d located in segment %d starting at LSN %d", lsn, segments[start], base)
+		}
+		if start+1 < len(segments) {
+			next, _, _, err := w.index.describe(segments[start+1])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if next <= lsn {
+				t.Fatalf("LSN %d located in segment %d, but segment %d starts at LSN %d", lsn, segments[start], segments[start+1], next)
+			}
+		}
+	}
+}
+
+func TestIndexForgetsRewrittenSegments(t *testing.T) {
+	w := openTestWAL(t, t.TempDir(), truncateOptions())
+	writeEntries(t, w, 30)
+	if _, err := w.Get(25); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.TruncateBack(20); err != nil {
+		t.Fatal(err)
+	}
+	for i := range 10 {
+		if _, err := w.WriteEntry([]byte(fmt.Sprintf("new %d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	entry, err := w.Get(25)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(entry.Data) != "new 4" {
+		t.Fatalf("Get(25) = %q, want %q", entry.Data, "new 4")
+	}
+}
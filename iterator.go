@@ -0,0 +1,251 @@
+package wal
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+)
+
+// Iterator streams WAL entries across segments in LSN order.
+//
+// Unlike ReadAll, an Iterator holds at most one segment open and one entry in
+// memory at a time, so it can walk logs of any size in constant memory.
+//
+// Typical usage:
+//
+//	it, err := w.ReadFrom(lsn)
+//	if err != nil {
+//		return err
+//	}
+//	defer it.Close()
+//
+//	for it.Next() {
+//		entry := it.Entry()
+//		// Process entry
+//	}
+//	if err := it.Err(); err != nil {
+//		return err
+//	}
+//
+// An Iterator is not safe for concurrent use.
+type Iterator struct {
+	// source opens the segments to read
+	source *segmentSource
+	// segments are the segment IDs left to read
+	segments []int
+	// startOffset is the offset to start reading
+	// the first segment at
+	startOffset int64
+	// segmentID is the segment currently being read
+	segmentID int
+	// reader is the segment currently being read
+	reader io.ReadCloser
+	// entryReader reads entries from the current segment
+	entryReader *BinaryEntryReader
+	// fromLSN is the first LSN to yield
+	fromLSN uint64
+	// entry is the current entry
+	entry *WAL_Entry
+	// err is the first error encountered
+	err error
+	// done is whether the iterator is exhausted or closed
+	done bool
+}
+
+// ReadFrom returns an Iterator over all entries with an LSN greater than or equal
+// to lsn, in LSN order. ReadFrom(0) iterates over the whole log.
+//
+// ReadFrom uses the LSN index to jump directly to the segment and offset holding
+// lsn instead of scanning the log from the beginning.
+//
+// The set of segments is captured when ReadFrom is called; entries flushed to
+// those segments afterwards are still visible to the iterator. The iterator must
+// be closed with Close.
+//
+// Entries removed by TruncateFront are never returned.
+//
+// This method is safe to call while the WAL is actively being written to.
+func (w *WAL) ReadFrom(lsn uint64) (*Iterator, error) {
+	w.mu.Lock()
+	lsn = max(lsn, w.firstLSN)
+	w.mu.Unlock()
+
+	segments, err := w.segmentMgr.ListSegments()
+	if err != nil {
+		return nil, err
+	}
+
+	start, offset, err := w.index.locate(segments, lsn)
+	if err != nil {
+		return nil, fmt.Errorf("locate LSN %d: %w", lsn, err)
+	}
+
+	return &Iterator{
+		source:      w.source,
+		segments:    segments[start:],
+		startOffset: offset,
+		fromLSN:     lsn,
+	}, nil
+}
+
+// Get returns the entry with the given LSN.
+//
+// Get uses the LSN index to read only the part of the segment around the entry.
+// Returns an error wrapping ErrEntryNotFound if no entry has the given LSN.
+//
+// This method is safe to call while the WAL is actively being written to.
+func (w *WAL) Get(lsn uint64) (*WAL_Entry, error) {
+	it, err := w.ReadFrom(lsn)
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+
+	if !it.Next() {
+		if err := it.Err(); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: LSN %d", ErrEntryNotFound, lsn)
+	}
+
+	entry := it.Entry()
+	if entry.LogSequenceNumber != lsn {
+		return nil, fmt.Errorf("%w: LSN %d", ErrEntryNotFound, lsn)
+	}
+	return entry, nil
+}
+
+// Entries returns a Go iterator over all entries with an LSN greater than or equal
+// to lsn, in LSN order.
+//
+// Iteration stops at the first error, which is yielded with a nil entry.
+//
+//	for entry, err := range w.Entries(lsn) {
+//		if err != nil {
+//			return err
+//		}
+//		// Process entry
+//	}
+func (w *WAL) Entries(lsn uint64) iter.Seq2[*WAL_Entry, error] {
+	return func(yield func(*WAL_Entry, error) bool) {
+		it, err := w.ReadFrom(lsn)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		defer it.Close()
+
+		for it.Next() {
+			if !yield(it.Entry(), nil) {
+				return
+			}
+		}
+		if err := it.Err(); err != nil {
+			yield(nil, err)
+		}
+	}
+}
+
+// Next advances the iterator to the next entry, which is then available
+// through Entry. It returns false when there are no more entries or an
+// error occurred, which is then available through Err.
+func (it *Iterator) Next() bool {
+	for !it.done {
+		if it.entryReader == nil {
+			if len(it.segments) == 0 {
+				it.finish(nil)
+				return false
+			}
+			if err := it.openSegment(it.segments[0]); err != nil {
+				it.finish(err)
+				return false
+			}
+			it.segments = it.segments[1:]
+		}
+
+		entry, err := it.entryReader.ReadVerifiedEntry()
+		if err == io.EOF || (errors.Is(err, ErrTruncatedEntry) && len(it.segments) == 0) {
+			// A truncated entry at the end of the last segment is a
+			// write still being flushed, not corruption
+			it.closeSegment()
+			continue
+		}
+		if err != nil {
+			it.finish(fmt.Errorf("read segment %d: %w", it.segmentID, err))
+			return false
+		}
+
+		if entry.LogSequenceNumber < it.fromLSN {
+			continue
+		}
+
+		it.entry = entry
+		return true
+	}
+	return false
+}
+
+// Entry returns the current entry.
+//
+// Entry is only valid after a call to Next returned true.
+func (it *Iterator) Entry() *WAL_Entry {
+	return it.entry
+}
+
+// inBatch reports whether the current entry is followed
+// by other entries of the same batch
+func (it *Iterator) inBatch() bool {
+	return it.entryReader != nil && it.entryReader.inBatch()
+}
+
+// Err returns the first error encountered by the iterator, if any.
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+// Close releases the segment held open by the iterator.
+//
+// Close is safe to call multiple times.
+func (it *Iterator) Close() error {
+	it.done = true
+	it.entry = nil
+	return it.closeSegment()
+}
+
+// openSegment opens the given segment for reading
+// the first segment is opened at the start offset
+func (it *Iterator) openSegment(id int) error {
+	reader, entryReader, err := it.source.openAt(id, it.startOffset)
+	if err != nil {
+		return err
+	}
+	it.startOffset = 0
+
+	it.segmentID = id
+	it.reader = reader
+	it.entryReader = entryReader
+	return nil
+}
+
+// closeSegment closes the segment currently being read
+func (it *Iterator) closeSegment() error {
+	if it.reader == nil {
+		return nil
+	}
+
+	err := it.reader.Close()
+	it.reader = nil
+	it.entryReader = nil
+	return err
+}
+
+// finish stops the iterator, recording err if it is the first error
+func (it *Iterator) finish(err error) {
+	if it.err == nil {
+		it.err = err
+	}
+	it.done = true
+	it.entry = nil
+	it.closeSegment()
+}
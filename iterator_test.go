@@ -0,0 +1,129 @@
+package wal
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestReadFrom(t *testing.T) {
+	w := openTestWAL(t, t.TempDir(), truncateOptions())
+	writeEntries(t, w, 50)
+	if err := w.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, from := range []uint64{0, 1, 17, 50, 51} {
+		it, err := w.ReadFrom(from)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []uint64
+		for it.Next() {
+			got = append(got, it.Entry().LogSequenceNumber)
+		}
+		if err := it.Err(); err != nil {
+			t.Fatal(err)
+		}
+		if err := it.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if want := lsnRange(max(from, 1), 50); !slices.Equal(got, want) {
+			t.Fatalf("ReadFrom(%d) = %v, want %v", from, got, want)
+		}
+	}
+}
+
+func TestReadFromSeesLaterFlushes(t *testing.T) {
+	w := openTestWAL(t, t.TempDir(), testOptions())
+	writeEntries(t, w, 3)
+	if err := w.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	it, err := w.ReadFrom(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Close()
+	for range 3 {
+		if !it.Next() {
+			t.Fatalf("Next() = false: %v", it.Err())
+		}
+	}
+
+	// Entries flushed to the same segment afterwards are visible
+	writeEntries(t, w, 1)
+	if err := w.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if !it.Next() || it.Entry().LogSequenceNumber != 4 {
+		t.Fatalf("Next() did not return LSN 4: %v", it.Err())
+	}
+}
+
+func TestIteratorClose(t *testing.T) {
+	w := openTestWAL(t, t.TempDir(), testOptions())
+	writeEntries(t, w, 3)
+	if err := w.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	it, err := w.ReadFrom(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !it.Next() {
+		t.Fatalf("Next() = false: %v", it.Err())
+	}
+	if err := it.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := it.Close(); err != nil {
+		t.Fatalf("second Close() error = %v", err)
+	}
+	if it.Next() {
+		t.Fatal("Next() = true after Close")
+	}
+}
+
+func TestEntriesStopsEarly(t *testing.T) {
+	w := openTestWAL(t, t.TempDir(), truncateOptions())
+	writeEntries(t, w, 20)
+	if err := w.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []uint64
+	for entry, err := range w.Entries(5) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, entry.LogSequenceNumber)
+		if len(got) == 3 {
+			break
+		}
+	}
+	if !slices.Equal(got, []uint64{5, 6, 7}) {
+		t.Fatalf("Entries(5) = %v, want [5 6 7]", got)
+	}
+}
+
+func TestGet(t *testing.T) {
+	w := openTestWAL(t, t.TempDir(), truncateOptions())
+	writeEntries(t, w, 20)
+	if err := w.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	entry, err := w.Get(13)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.LogSequenceNumber != 13 || string(entry.Data) != "entry 12" {
+		t.Fatalf("Get(13) = LSN %d %q", entry.LogSequenceNumber, entry.Data)
+	}
+	if _, err := w.Get(21); !errors.Is(err, ErrEntryNotFound) {
+		t.Fatalf("Get(21) error = %v, want ErrEntryNotFound", err)
+	}
+}
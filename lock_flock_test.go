@@ -0,0 +1,60 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package wal
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestSecondWriterIsLockedOut(t *testing.T) {
+	dir := t.TempDir()
+	w := openTestWAL(t, dir, testOptions())
+	writeEntries(t, w, 1)
+
+	segmentMgr, err := NewFileSegmentManager(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second, err := Open(segmentMgr, testOptions()); !errors.Is(err, ErrLocked) {
+		if err == nil {
+			second.Close()
+		}
+		t.Fatalf("Open() error = %v, want ErrLocked", err)
+	}
+	segmentMgr.Close()
+
+	// Close releases the lock
+	w.Close()
+	w = openTestWAL(t, dir, testOptions())
+	if lsn, err := w.WriteEntry([]byte("next")); err != nil || lsn != 2 {
+		t.Fatalf("WriteEntry() = %d, %v, want 2", lsn, err)
+	}
+}
+
+func TestFailedOpenReleasesLock(t *testing.T) {
+	dir := t.TempDir()
+	w := openTestWAL(t, dir, testOptions())
+	writeEntries(t, w, 10)
+	w.Close()
+
+	// Damage the payload of the first entry
+	path := segmentPath(dir, 0)
+	flipByte(t, path, SegmentHeaderSize+frameHeaderSize+2)
+
+	segmentMgr, err := NewFileSegmentManager(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(segmentMgr, testOptions()); !isCorruption(err) {
+		t.Fatalf("Open() error = %v, want a CorruptionError", err)
+	}
+
+	// Once repaired, the directory can be opened again
+	flipByte(t, path, SegmentHeaderSize+frameHeaderSize+2)
+	w = openTestWAL(t, dir, testOptions())
+	if got := readLSNs(t, w); !slices.Equal(got, lsnRange(1, 10)) {
+		t.Fatalf("LSNs = %v, want 1 to 10", got)
+	}
+}
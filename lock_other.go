@@ -0,0 +1,11 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package wal
+
+import "os"
+
+// lockFile does nothing on platforms without flock, where
+// directories are not protected against a second writer
+func lockFile(file *os.File) error {
+	return nil
+}
@@ -0,0 +1,60 @@
+package raftstore
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+)
+
+// stablePrefix is the prefix of the meta keys holding stable values
+// keys are hex-encoded so that any key can be stored
+const stablePrefix = "raft-"
+
+// Set stores a stable value atomically.
+func (s *Store) Set(key []byte, val []byte) error {
+	if val == nil {
+		val = []byte{}
+	}
+	if err := s.meta.StoreMeta(stableKey(key), val); err != nil {
+		return fmt.Errorf("set %q: %w", key, err)
+	}
+	return nil
+}
+
+// Get returns a stable value.
+//
+// Returns ErrKeyNotFound if the key was never set.
+func (s *Store) Get(key []byte) ([]byte, error) {
+	val, err := s.meta.LoadMeta(stableKey(key))
+	if err != nil {
+		return nil, fmt.Errorf("get %q: %w", key, err)
+	}
+	if val == nil {
+		return nil, ErrKeyNotFound
+	}
+	return val, nil
+}
+
+// SetUint64 stores a stable integer value atomically.
+func (s *Store) SetUint64(key []byte, val uint64) error {
+	return s.Set(key, binary.BigEndian.AppendUint64(nil, val))
+}
+
+// GetUint64 returns a stable integer value.
+//
+// Returns ErrKeyNotFound if the key was never set.
+func (s *Store) GetUint64(key []byte) (uint64, error) {
+	val, err := s.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	if len(val) != 8 {
+		return 0, fmt.Errorf("get %q: value of %d bytes is not an integer", key, len(val))
+	}
+	return binary.BigEndian.Uint64(val), nil
+}
+
+// stableKey returns the meta key of a stable value
+func stableKey(key []byte) string {
+	return stablePrefix + hex.EncodeToString(key)
+}
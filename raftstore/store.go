@@ -0,0 +1,307 @@
+// Package raftstore implements the log and stable stores of a Raft node on
+// top of a WAL.
+//
+// Raft log indexes map one to one to LSNs: storing logs appends them at their
+// index, deleting a suffix after a conflict truncates the back of the WAL and
+// compacting a prefix after a snapshot truncates its front. Stable values such
+// as the current term are stored atomically next to the segments.
+//
+// The method set follows the LogStore and StableStore interfaces of
+// github.com/hashicorp/raft, so a Store can replace BoltDB with a thin wrapper
+// converting between Log and raft.Log.
+package raftstore
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/wizenheimer/wal"
+)
+
+var (
+	// ErrLogNotFound is returned when no log has the requested index
+	ErrLogNotFound = errors.New("log not found")
+	// ErrKeyNotFound is returned when no stable value has the requested key
+	ErrKeyNotFound = errors.New("not found")
+	// ErrNonContiguous is returned when logs do not follow the last stored index
+	ErrNonContiguous = errors.New("non-contiguous log indexes")
+)
+
+// LogType is the type of a log entry, as defined by the Raft library.
+type LogType uint8
+
+// Log is a Raft log entry.
+type Log struct {
+	// Index is the index of the log, its LSN in the WAL
+	Index uint64
+	// Term is the election term of the log
+	Term uint64
+	// Type is the type of the log
+	Type LogType
+	// Data is the payload of the log
+	Data []byte
+	// Extensions holds extra data attached by the Raft library
+	Extensions []byte
+	// AppendedAt is when the leader appended the log
+	AppendedAt time.Time
+}
+
+// Store is a Raft log and stable store backed by a WAL.
+//
+// The WAL must only be written to by the Store, and its retention must keep
+// every segment: Raft decides which logs to compact with DeleteRange.
+//
+// A Store is safe for concurrent use.
+type Store struct {
+	// w is the WAL holding the logs
+	w *wal.WAL
+	// meta holds the stable values
+	meta wal.MetaStore
+
+	// mu serializes changes to the logs
+	mu sync.Mutex
+	// first is the index of the first log
+	// it is 0 when the store holds no logs
+	first uint64
+}
+
+// Open opens a Store whose WAL and stable values are stored in dir.
+//
+// Retention is disabled on the WAL.
+func Open(dir string, opts wal.WALOptions) (*Store, error) {
+	segmentMgr, err := wal.NewFileSegmentManager(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	opts.Retention = nil
+	opts.MaxSegments = 0
+	w, err := wal.Open(segmentMgr, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := New(w, segmentMgr)
+	if err != nil {
+		w.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// New creates a Store on top of an open WAL, storing the stable values in meta.
+func New(w *wal.WAL, meta wal.MetaStore) (*Store, error) {
+	s := &Store{w: w, meta: meta}
+
+	for entry, err := range w.Entries(0) {
+		if err != nil {
+			return nil, fmt.Errorf("find first log: %w", err)
+		}
+		s.first = entry.LogSequenceNumber
+		break
+	}
+	return s, nil
+}
+
+// Close closes the underlying WAL.
+func (s *Store) Close() error {
+	return s.w.Close()
+}
+
+// FirstIndex returns the index of the first log, or 0 if there are none.
+func (s *Store) FirstIndex() (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.first, nil
+}
+
+// LastIndex returns the index of the last log, or 0 if there are none.
+func (s *Store) LastIndex() (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.first == 0 {
+		return 0, nil
+	}
+	return s.w.LastLSN(), nil
+}
+
+// GetLog reads the log with the given index into log.
+//
+// Returns ErrLogNotFound if there is no such log.
+func (s *Store) GetLog(index uint64, log *Log) error {
+	s.mu.Lock()
+	first, last := s.first, s.w.LastLSN()
+	s.mu.Unlock()
+
+	if first == 0 || index < first || index > last {
+		return ErrLogNotFound
+	}
+
+	entry, err := s.w.Get(index)
+	if errors.Is(err, wal.ErrEntryNotFound) {
+		return ErrLogNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return decodeLog(entry, log)
+}
+
+// StoreLog stores a single log.
+func (s *Store) StoreLog(log *Log) error {
+	return s.StoreLogs([]*Log{log})
+}
+
+// StoreLogs stores logs with contiguous indexes and syncs them to disk.
+//
+// Logs at or before the last index replace the stored logs from their index
+// on, which is how conflicting logs from an older term are overwritten. The
+// first log stored when the store is empty may start at any index after the
+// last log compacted by DeleteRange, for example after installing a snapshot.
+// Other indexes fail with ErrNonContiguous.
+func (s *Store) StoreLogs(logs []*Log) error {
+	if len(logs) == 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	index := logs[0].Index
+	entries := make([]*wal.WAL_Entry, len(logs))
+	for i, log := range logs {
+		if log.Index != index+uint64(i) {
+			return fmt.Errorf("%w: log %d follows log %d", ErrNonContiguous, log.Index, index+uint64(i)-1)
+		}
+		entries[i] = wal.NewEntry(log.Index, encodeLog(log))
+	}
+
+	last := s.w.LastLSN()
+	switch {
+	case s.first == 0 && index > last+1:
+		// The log is empty, for example after a snapshot
+		// was installed, start it at the first index
+		if err := s.w.TruncateFront(index); err != nil {
+			return fmt.Errorf("move first index to %d: %w", index, err)
+		}
+	case s.first == 0 && index <= last:
+		// The WAL never reuses the LSNs of compacted logs
+		return fmt.Errorf("%w: log %d is not after the last compacted log %d", ErrNonContiguous, index, last)
+	case s.first != 0 && index <= last:
+		if index < s.first {
+			return fmt.Errorf("%w: log %d is before the first index %d", ErrNonContiguous, index, s.first)
+		}
+		if err := s.w.TruncateBack(index - 1); err != nil {
+			return fmt.Errorf("delete conflicting logs from %d: %w", index, err)
+		}
+	case index != last+1:
+		return fmt.Errorf("%w: log %d after last index %d", ErrNonContiguous, index, last)
+	}
+
+	// Some logs may be stored when appending fails
+	err := s.w.AppendEntries(entries)
+	if s.first == 0 && s.w.LastLSN() >= index {
+		s.first = index
+	}
+	if err != nil {
+		return fmt.Errorf("store logs %d to %d: %w", index, logs[len(logs)-1].Index, err)
+	}
+
+	return s.w.Sync()
+}
+
+// DeleteRange deletes the logs with an index between min and max inclusive.
+//
+// The range must include the first or the last log: deleting a prefix
+// compacts the log after a snapshot, and deleting a suffix removes logs
+// conflicting with the leader. A range including every log is deleted as a
+// suffix, so the leader can store its logs from the first index again.
+func (s *Store) DeleteRange(min, max uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.first == 0 || min > max || max < s.first {
+		return nil
+	}
+	last := s.w.LastLSN()
+
+	switch {
+	case max >= last:
+		// Checked first so that deleting every log after a conflict
+		// lets the leader store its logs from the same index again
+		from := min
+		if from < s.first {
+			from = s.first
+		}
+		if err := s.w.TruncateBack(from - 1); err != nil {
+			return fmt.Errorf("delete logs from %d: %w", from, err)
+		}
+		if from == s.first {
+			s.first = 0
+		}
+	case min <= s.first:
+		if err := s.w.TruncateFront(max + 1); err != nil {
+			return fmt.Errorf("delete logs up to %d: %w", max, err)
+		}
+		s.first = max + 1
+	default:
+		return fmt.Errorf("cannot delete logs %d to %d in the middle of logs %d to %d", min, max, s.first, last)
+	}
+
+	return nil
+}
+
+// encodeLog encodes a log as the data of a WAL entry
+//   - 8 bytes: term (little-endian)
+//   - 1 byte: type
+//   - 8 bytes: appended at, in Unix nanoseconds (little-endian)
+//   - 4 bytes: length of the extensions (little-endian)
+//   - N bytes: extensions
+//   - the rest: data
+func encodeLog(log *Log) []byte {
+	buf := make([]byte, 0, logHeaderSize+len(log.Extensions)+len(log.Data))
+	buf = binary.LittleEndian.AppendUint64(buf, log.Term)
+	buf = append(buf, byte(log.Type))
+	var appendedAt int64
+	if !log.AppendedAt.IsZero() {
+		appendedAt = log.AppendedAt.UnixNano()
+	}
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(appendedAt))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(log.Extensions)))
+	buf = append(buf, log.Extensions...)
+	return append(buf, log.Data...)
+}
+
+// logHeaderSize is the size of the fixed fields of an encoded log
+const logHeaderSize = 21
+
+// decodeLog decodes a log encoded by encodeLog
+func decodeLog(entry *wal.WAL_Entry, log *Log) error {
+	data := entry.Data
+	if len(data) < logHeaderSize {
+		return fmt.Errorf("%w: log %d is %d bytes long", wal.ErrCorruptEntry, entry.LogSequenceNumber, len(data))
+	}
+	size := binary.LittleEndian.Uint32(data[17:21])
+	if uint64(size) > uint64(len(data)-logHeaderSize) {
+		return fmt.Errorf("%w: log %d has %d bytes of extensions", wal.ErrCorruptEntry, entry.LogSequenceNumber, size)
+	}
+
+	*log = Log{
+		Index: entry.LogSequenceNumber,
+		Term:  binary.LittleEndian.Uint64(data[0:8]),
+		Type:  LogType(data[8]),
+		Data:  data[logHeaderSize+size:],
+	}
+	if appendedAt := int64(binary.LittleEndian.Uint64(data[9:17])); appendedAt != 0 {
+		log.AppendedAt = time.Unix(0, appendedAt)
+	}
+	if size > 0 {
+		log.Extensions = data[logHeaderSize : logHeaderSize+size]
+	}
+	return nil
+}
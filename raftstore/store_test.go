@@ -0,0 +1,192 @@
+package raftstore
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/wizenheimer/wal"
+)
+
+// openTestStore opens a Store in dir that is closed when the test ends
+func openTestStore(t *testing.T, dir string) *Store {
+	t.Helper()
+
+	s, err := Open(dir, wal.DefaultWALOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// storeRange stores logs first to last of the given term
+func storeRange(t *testing.T, s *Store, first, last, term uint64) {
+	t.Helper()
+
+	var logs []*Log
+	for index := first; index <= last; index++ {
+		logs = append(logs, &Log{Index: index, Term: term, Data: []byte(fmt.Sprintf("log %d", index))})
+	}
+	if err := s.StoreLogs(logs); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// checkIndexes checks the first and last index of the store
+func checkIndexes(t *testing.T, s *Store, first, last uint64) {
+	t.Helper()
+
+	if got, err := s.FirstIndex(); err != nil || got != first {
+		t.Fatalf("FirstIndex() = %d, %v, want %d", got, err, first)
+	}
+	if got, err := s.LastIndex(); err != nil || got != last {
+		t.Fatalf("LastIndex() = %d, %v, want %d", got, err, last)
+	}
+}
+
+func TestStoreLogs(t *testing.T) {
+	s := openTestStore(t, t.TempDir())
+	checkIndexes(t, s, 0, 0)
+
+	storeRange(t, s, 1, 10, 1)
+	checkIndexes(t, s, 1, 10)
+
+	var log Log
+	if err := s.GetLog(4, &log); err != nil {
+		t.Fatal(err)
+	}
+	if log.Index != 4 || log.Term != 1 || string(log.Data) != "log 4" {
+		t.Fatalf("GetLog(4) = %+v", log)
+	}
+	if err := s.GetLog(11, &log); !errors.Is(err, ErrLogNotFound) {
+		t.Fatalf("GetLog(11) error = %v, want ErrLogNotFound", err)
+	}
+}
+
+func TestStoreLogsOverwritesConflictingLogs(t *testing.T) {
+	s := openTestStore(t, t.TempDir())
+	storeRange(t, s, 1, 10, 1)
+
+	storeRange(t, s, 6, 7, 2)
+	checkIndexes(t, s, 1, 7)
+
+	var log Log
+	if err := s.GetLog(5, &log); err != nil || log.Term != 1 {
+		t.Fatalf("GetLog(5) = %+v, %v, want term 1", log, err)
+	}
+	if err := s.GetLog(6, &log); err != nil || log.Term != 2 {
+		t.Fatalf("GetLog(6) = %+v, %v, want term 2", log, err)
+	}
+	if err := s.GetLog(8, &log); !errors.Is(err, ErrLogNotFound) {
+		t.Fatalf("GetLog(8) error = %v, want ErrLogNotFound", err)
+	}
+}
+
+func TestStoreLogsRejectsGaps(t *testing.T) {
+	s := openTestStore(t, t.TempDir())
+	storeRange(t, s, 1, 5, 1)
+
+	if err := s.StoreLog(&Log{Index: 7, Term: 1}); !errors.Is(err, ErrNonContiguous) {
+		t.Fatalf("StoreLog(7) error = %v, want ErrNonContiguous", err)
+	}
+	logs := []*Log{{Index: 6, Term: 1}, {Index: 8, Term: 1}}
+	if err := s.StoreLogs(logs); !errors.Is(err, ErrNonContiguous) {
+		t.Fatalf("StoreLogs(6, 8) error = %v, want ErrNonContiguous", err)
+	}
+	checkIndexes(t, s, 1, 5)
+}
+
+func TestDeleteRange(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestStore(t, dir)
+	storeRange(t, s, 1, 20, 1)
+
+	// Compact a prefix
+	if err := s.DeleteRange(1, 5); err != nil {
+		t.Fatal(err)
+	}
+	checkIndexes(t, s, 6, 20)
+
+	// Remove a suffix
+	if err := s.DeleteRange(16, 20); err != nil {
+		t.Fatal(err)
+	}
+	checkIndexes(t, s, 6, 15)
+
+	if err := s.DeleteRange(8, 10); err == nil {
+		t.Fatal("DeleteRange() succeeded in the middle of the logs")
+	}
+
+	s.Close()
+	s = openTestStore(t, dir)
+	checkIndexes(t, s, 6, 15)
+}
+
+func TestStoreLogsAfterDeletingEveryLog(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestStore(t, dir)
+	storeRange(t, s, 1, 5, 1)
+
+	// A follower replaces its whole log after a conflict
+	if err := s.DeleteRange(1, 5); err != nil {
+		t.Fatal(err)
+	}
+	checkIndexes(t, s, 0, 0)
+	storeRange(t, s, 1, 3, 2)
+	checkIndexes(t, s, 1, 3)
+
+	s.Close()
+	s = openTestStore(t, dir)
+	checkIndexes(t, s, 1, 3)
+	var log Log
+	if err := s.GetLog(1, &log); err != nil || log.Term != 2 {
+		t.Fatalf("GetLog(1) = %+v, %v, want term 2", log, err)
+	}
+}
+
+func TestStoreLogsAfterCompactingEveryLog(t *testing.T) {
+	s := openTestStore(t, t.TempDir())
+	storeRange(t, s, 1, 10, 1)
+
+	if err := s.DeleteRange(1, 5); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteRange(6, 10); err != nil {
+		t.Fatal(err)
+	}
+	checkIndexes(t, s, 0, 0)
+
+	// Compacted indexes are never reused
+	if err := s.StoreLog(&Log{Index: 5, Term: 2}); !errors.Is(err, ErrNonContiguous) {
+		t.Fatalf("StoreLog(5) error = %v, want ErrNonContiguous", err)
+	}
+
+	// A snapshot was installed up to log 30
+	storeRange(t, s, 31, 35, 2)
+	checkIndexes(t, s, 31, 35)
+}
+
+func TestStableStore(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestStore(t, dir)
+
+	if _, err := s.Get([]byte("missing")); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("Get() error = %v, want ErrKeyNotFound", err)
+	}
+	if err := s.SetUint64([]byte("CurrentTerm"), 7); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Set([]byte("LastVoteCand"), []byte("node-2")); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Close()
+	s = openTestStore(t, dir)
+	if term, err := s.GetUint64([]byte("CurrentTerm")); err != nil || term != 7 {
+		t.Fatalf("GetUint64() = %d, %v, want 7", term, err)
+	}
+	if val, err := s.Get([]byte("LastVoteCand")); err != nil || string(val) != "node-2" {
+		t.Fatalf("Get() = %q, %v, want %q", val, err, "node-2")
+	}
+}
@@ -0,0 +1,405 @@
+package wal
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// replicationMagic starts every replication request
+var replicationMagic = [4]byte{'W', 'R', 'E', 'P'}
+
+// replicationRequestSize is the size of a replication request
+// the magic followed by the first LSN to stream
+const replicationRequestSize = 12
+
+// frameFlagHeartbeat marks an empty frame a replication server sends
+// while idle, only follower readers accept it
+const frameFlagHeartbeat uint32 = 1 << 10
+
+var (
+	defaultRetryInterval     = time.Second
+	defaultHeartbeatInterval = time.Second
+	defaultReadTimeout       = 10 * time.Second
+)
+
+// ReplicationServer streams the entries of a WAL to followers over TCP.
+//
+// A follower connects and sends the first LSN it needs; the server replays the
+// entries from that LSN out of the segments and then streams new entries as they
+// are flushed. The stream is laid out like a segment: a SegmentHeader followed by
+// checksummed frames, so followers verify every entry end to end. The entries
+// of a batch written by WriteBatch are sent in a single frame, and an empty
+// heartbeat frame is sent every heartbeat interval so followers can tell an
+// idle server from a dead one. After TruncateBack removes entries a follower
+// received, the stream goes back to the first removed LSN.
+//
+// A ReplicationServer is safe for concurrent use.
+type ReplicationServer struct {
+	// w is the WAL to replicate
+	w *WAL
+	// heartbeatInterval is the delay between heartbeats
+	heartbeatInterval time.Duration
+
+	// mu guards the fields below
+	mu sync.Mutex
+	// listeners are the listeners being served
+	listeners map[net.Listener]struct{}
+	// conns are the open follower connections
+	conns map[net.Conn]struct{}
+	// closed is whether the server was closed
+	closed bool
+	// wg waits for the connection handlers
+	wg sync.WaitGroup
+}
+
+// NewReplicationServer creates a ReplicationServer for the given WAL.
+func NewReplicationServer(w *WAL) *ReplicationServer {
+	return &ReplicationServer{
+		w:                 w,
+		heartbeatInterval: defaultHeartbeatInterval,
+		listeners:         make(map[net.Listener]struct{}),
+		conns:             make(map[net.Conn]struct{}),
+	}
+}
+
+// SetHeartbeatInterval sets the delay between the heartbeats sent to followers.
+//
+// It must be shorter than the read timeout of the followers and set before
+// Serve is called. The default is one second.
+func (s *ReplicationServer) SetHeartbeatInterval(interval time.Duration) {
+	s.heartbeatInterval = interval
+}
+
+// Serve accepts follower connections on l and streams entries to them until
+// the server is closed, in which case it returns ErrClosed.
+//
+//	l, err := net.Listen("tcp", ":7000")
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	go server.Serve(l)
+func (s *ReplicationServer) Serve(l net.Listener) error {
+	if !s.track(l, nil) {
+		l.Close()
+		return ErrClosed
+	}
+	defer s.untrack(l, nil)
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrClosed
+			}
+			return fmt.Errorf("accept: %w", err)
+		}
+
+		if !s.track(nil, conn) {
+			conn.Close()
+			return ErrClosed
+		}
+		go func() {
+			defer s.wg.Done()
+			defer s.untrack(nil, conn)
+
+			if err := s.serveConn(conn); err != nil {
+				log.Printf("Warning: replication to %s: %v", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+// Close stops accepting followers, disconnects the connected ones and waits
+// for their handlers to return.
+func (s *ReplicationServer) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return nil
+}
+
+// track registers a listener or a connection
+// it returns false if the server is closed
+func (s *ReplicationServer) track(l net.Listener, conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return false
+	}
+	if l != nil {
+		s.listeners[l] = struct{}{}
+	}
+	if conn != nil {
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+	}
+	return true
+}
+
+// untrack unregisters a listener or a connection and closes it
+func (s *ReplicationServer) untrack(l net.Listener, conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if l != nil {
+		delete(s.listeners, l)
+		l.Close()
+	}
+	if conn != nil {
+		delete(s.conns, conn)
+		conn.Close()
+	}
+}
+
+// serveConn streams entries to a single follower
+func (s *ReplicationServer) serveConn(conn net.Conn) error {
+	var req [replicationRequestSize]byte
+	if _, err := io.ReadFull(conn, req[:]); err != nil {
+		return fmt.Errorf("read request: %w", err)
+	}
+	if [4]byte(req[0:4]) != replicationMagic {
+		return fmt.Errorf("invalid replication request")
+	}
+	// A follower past the end of the WAL holds entries removed by
+	// TruncateBack, streaming from the end makes it remove them
+	fromLSN := min(max(binary.LittleEndian.Uint64(req[4:12]), 1), s.w.LastLSN()+1)
+
+	// The follower sends nothing else, a read returning
+	// means it disconnected
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		io.Copy(io.Discard, conn)
+		cancel()
+	}()
+
+	sub := s.w.subscribeBatches(ctx, fromLSN)
+	defer sub.Close()
+
+	algo := s.w.options.Checksum
+	entryWriter := NewBinaryEntryWriter(conn)
+	entryWriter.SetMaxRecordSize(s.w.options.maxRecordSize())
+	if err := entryWriter.WriteHeader(newSegmentHeader(fromLSN, algo)); err != nil {
+		return fmt.Errorf("write header: %w", err)
+	}
+	if err := entryWriter.Flush(); err != nil {
+		return fmt.Errorf("write header: %w", err)
+	}
+
+	heartbeat := time.NewTicker(s.heartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case batch, ok := <-sub.batches:
+			if !ok {
+				if err := sub.Err(); err != nil && ctx.Err() == nil {
+					return err
+				}
+				return nil
+			}
+			if err := sendBatch(entryWriter, algo, batch); err != nil {
+				return err
+			}
+
+			// Send once caught up with the subscription
+			if len(sub.batches) > 0 {
+				continue
+			}
+		case <-heartbeat.C:
+			if err := entryWriter.writeFrame(nil, frameFlagHeartbeat); err != nil {
+				return fmt.Errorf("send heartbeat: %w", err)
+			}
+		}
+
+		if err := entryWriter.Flush(); err != nil {
+			return fmt.Errorf("send entries: %w", err)
+		}
+	}
+}
+
+// sendBatch writes the entries of a record to a replication stream,
+// those of a batch in a single frame so followers apply them atomically
+func sendBatch(entryWriter *BinaryEntryWriter, algo ChecksumAlgorithm, batch []*WAL_Entry) error {
+	// Segments may use other algorithms than the stream
+	for _, entry := range batch {
+		entry.CRC = entryCRC(algo, entry)
+	}
+
+	first, last := batch[0].LogSequenceNumber, batch[len(batch)-1].LogSequenceNumber
+	if len(batch) == 1 {
+		if err := entryWriter.WriteEntry(batch[0]); err != nil {
+			return fmt.Errorf("send entry %d: %w", first, err)
+		}
+		return nil
+	}
+	if err := entryWriter.WriteBatch(batch); err != nil {
+		return fmt.Errorf("send entries %d to %d: %w", first, last, err)
+	}
+	return nil
+}
+
+// Follower replicates the entries streamed by a ReplicationServer into a
+// local WAL, preserving their LSNs.
+//
+// The local WAL must only be written to by the follower. The entries of a batch
+// written by WriteBatch on the server are appended atomically, so a crash of
+// the follower never keeps part of a batch. When nothing, not even a heartbeat,
+// is received for the read timeout, the server is considered dead. After a
+// disconnect the follower reconnects and resumes after the last LSN of the
+// local WAL.
+//
+// When the server goes back to an LSN the follower already holds, because
+// TruncateBack removed entries on the server, the follower removes them too
+// with TruncateBack before appending. Entries removed on the server while the
+// follower is disconnected are only detected if the server has not written
+// past them again by the time it reconnects.
+type Follower struct {
+	// w is the WAL to append to
+	w *WAL
+	// addr is the address of the replication server
+	addr string
+	// retryInterval is the delay before reconnecting
+	retryInterval time.Duration
+	// readTimeout is how long to wait for data from
+	// the server before disconnecting
+	readTimeout time.Duration
+}
+
+// NewFollower creates a Follower replicating from the server at addr into w.
+func NewFollower(w *WAL, addr string) *Follower {
+	return &Follower{
+		w:             w,
+		addr:          addr,
+		retryInterval: defaultRetryInterval,
+		readTimeout:   defaultReadTimeout,
+	}
+}
+
+// SetRetryInterval sets the delay before reconnecting after a disconnect.
+//
+// The default is one second.
+func (f *Follower) SetRetryInterval(interval time.Duration) {
+	f.retryInterval = interval
+}
+
+// SetReadTimeout sets how long to wait for data from the server before
+// disconnecting and reconnecting.
+//
+// It must be longer than the heartbeat interval of the server. The default is
+// ten seconds.
+func (f *Follower) SetReadTimeout(timeout time.Duration) {
+	f.readTimeout = timeout
+}
+
+// Run replicates until ctx is done, reconnecting after every disconnect, and
+// returns ctx.Err().
+//
+// Run returns early with an error wrapping ErrLSNOutOfRange if the server
+// cannot supply the next entry of the local WAL, for example because it was
+// removed by retention on the server, unless the Gaps option of the local WAL
+// is GapSkip. It returns early with an error wrapping ErrStaleTerm if the
+// server streams entries of a term older than the local term, because it is a
+// former leader.
+func (f *Follower) Run(ctx context.Context) error {
+	for {
+		err := f.replicate(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if errors.Is(err, ErrLSNOutOfRange) || errors.Is(err, ErrStaleTerm) {
+			return err
+		}
+		log.Printf("Warning: replication from %s: %v", f.addr, err)
+
+		select {
+		case <-time.After(f.retryInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// replicate appends the entries of a single connection
+func (f *Follower) replicate(ctx context.Context) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", f.addr)
+	if err != nil {
+		return fmt.Errorf("dial: %w", err)
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	fromLSN := f.w.LastLSN() + 1
+	req := binary.LittleEndian.AppendUint64(replicationMagic[:], fromLSN)
+	if _, err := conn.Write(req); err != nil {
+		return fmt.Errorf("send request: %w", err)
+	}
+
+	entryReader := NewBinaryEntryReader(&deadlineReader{conn: conn, timeout: f.readTimeout})
+	entryReader.SetMaxRecordSize(f.w.options.maxRecordSize())
+	entryReader.heartbeats = true
+	var batch []*WAL_Entry
+	for {
+		entry, err := entryReader.ReadVerifiedEntry()
+		if err != nil {
+			return fmt.Errorf("receive entry: %w", err)
+		}
+		batch = append(batch, entry)
+		if entryReader.inBatch() {
+			continue
+		}
+
+		if lsn := batch[0].LogSequenceNumber; lsn <= f.w.LastLSN() {
+			// The server removed the entries from lsn on
+			if err := f.w.TruncateBack(lsn - 1); err != nil {
+				return fmt.Errorf("truncate back to LSN %d: %w", lsn-1, err)
+			}
+		}
+		// The entries of a batch are appended atomically
+		if len(batch) == 1 {
+			err = f.w.AppendEntries(batch)
+		} else {
+			err = f.w.AppendBatch(batch)
+		}
+		if err != nil {
+			return err
+		}
+		batch = nil
+	}
+}
+
+// deadlineReader reads from a connection, failing when
+// nothing is received for the timeout
+type deadlineReader struct {
+	// conn is the connection to read from
+	conn net.Conn
+	// timeout is how long a read may wait
+	timeout time.Duration
+}
+
+func (r *deadlineReader) Read(p []byte) (int, error) {
+	if err := r.conn.SetReadDeadline(time.Now().Add(r.timeout)); err != nil {
+		return 0, err
+	}
+	return r.conn.Read(p)
+}
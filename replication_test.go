@@ -0,0 +1,290 @@
+package wal
+
+import (
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// startServer serves w on a local port and returns its address
+func startServer(t *testing.T, w *WAL) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewReplicationServer(w)
+	go server.Serve(l)
+	t.Cleanup(func() { server.Close() })
+	return l.Addr().String()
+}
+
+// startFollower replicates from addr into w until the test ends
+// or the returned function is called
+func startFollower(t *testing.T, w *WAL, addr string) (stop func()) {
+	t.Helper()
+
+	follower := NewFollower(w, addr)
+	follower.SetRetryInterval(10 * time.Millisecond)
+	return runFollower(t, follower)
+}
+
+// runFollower runs follower until the test ends
+// or the returned function is called
+func runFollower(t *testing.T, follower *Follower) (stop func()) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		follower.Run(ctx)
+	}()
+
+	stop = func() {
+		cancel()
+		<-done
+	}
+	t.Cleanup(stop)
+	return stop
+}
+
+// countingListener is a listener counting the connections it accepts
+type countingListener struct {
+	net.Listener
+	// accepted is the number of connections accepted
+	accepted atomic.Int32
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		l.accepted.Add(1)
+	}
+	return conn, err
+}
+
+// awaitAccepted waits until l accepted at least n connections
+func awaitAccepted(t *testing.T, l *countingListener, n int32) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for l.accepted.Load() < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d connections accepted, want %d", l.accepted.Load(), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// readData returns the data of every entry of the WAL
+func readData(t *testing.T, w *WAL) []string {
+	t.Helper()
+
+	if err := w.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	var data []string
+	for entry, err := range w.Entries(0) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		data = append(data, string(entry.Data))
+	}
+	return data
+}
+
+// awaitReplica waits until the replica holds the same entries as the leader
+func awaitReplica(t *testing.T, leader, replica *WAL) {
+	t.Helper()
+
+	want := readData(t, leader)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got := readData(t, replica)
+		if slices.Equal(got, want) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("replica holds %q, want %q", got, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// awaitSubscriptions waits until n subscriptions of the WAL are running
+func awaitSubscriptions(t *testing.T, w *WAL, n int) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		w.mu.Lock()
+		running := len(w.subscriptions)
+		w.mu.Unlock()
+		if running == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d subscriptions running, want %d", running, n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// writeData writes an entry for each of the given strings
+func writeData(t *testing.T, w *WAL, data ...string) {
+	t.Helper()
+
+	for _, d := range data {
+		if _, err := w.WriteEntry([]byte(d)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Sync(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFollowerReplicates(t *testing.T) {
+	leader := openTestWAL(t, t.TempDir(), truncateOptions())
+	replica := openTestWAL(t, t.TempDir(), truncateOptions())
+	writeEntries(t, leader, 10)
+
+	startFollower(t, replica, startServer(t, leader))
+	writeEntries(t, leader, 10)
+	if err := leader.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	awaitReplica(t, leader, replica)
+}
+
+func TestFollowerTruncatesBack(t *testing.T) {
+	leader := openTestWAL(t, t.TempDir(), truncateOptions())
+	replica := openTestWAL(t, t.TempDir(), truncateOptions())
+	startFollower(t, replica, startServer(t, leader))
+
+	writeEntries(t, leader, 20)
+	if err := leader.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	awaitReplica(t, leader, replica)
+
+	if err := leader.TruncateBack(12); err != nil {
+		t.Fatal(err)
+	}
+	writeData(t, leader, "new 0", "new 1", "new 2")
+	awaitReplica(t, leader, replica)
+}
+
+func TestFollowerTruncatesBackAfterReconnect(t *testing.T) {
+	leader := openTestWAL(t, t.TempDir(), truncateOptions())
+	replica := openTestWAL(t, t.TempDir(), truncateOptions())
+	addr := startServer(t, leader)
+
+	stop := startFollower(t, replica, addr)
+	writeEntries(t, leader, 20)
+	if err := leader.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	awaitReplica(t, leader, replica)
+	stop()
+	awaitSubscriptions(t, leader, 0)
+
+	// The replica is past the end of the leader when it reconnects,
+	// and removes its extra entries with the next entry streamed
+	if err := leader.TruncateBack(12); err != nil {
+		t.Fatal(err)
+	}
+	startFollower(t, replica, addr)
+	awaitSubscriptions(t, leader, 1)
+	writeData(t, leader, "new 0")
+	awaitReplica(t, leader, replica)
+}
+
+func TestFollowerAppliesBatchesAtomically(t *testing.T) {
+	leader := openTestWAL(t, t.TempDir(), truncateOptions())
+	replica := openTestWAL(t, t.TempDir(), truncateOptions())
+	startFollower(t, replica, startServer(t, leader))
+
+	writeData(t, leader, "a", "b")
+	var batch Batch
+	batch.Add([]byte("c"))
+	batch.Add([]byte("d"))
+	batch.Add([]byte("e"))
+	if _, err := leader.WriteBatch(&batch); err != nil {
+		t.Fatal(err)
+	}
+	if err := leader.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	awaitReplica(t, leader, replica)
+
+	// The batch is a single record on the replica too
+	if err := replica.TruncateBack(3); !errors.Is(err, ErrBatchSplit) {
+		t.Fatalf("TruncateBack(3) error = %v, want ErrBatchSplit", err)
+	}
+}
+
+func TestFollowerReconnectsWhenServerIsSilent(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	silent := &countingListener{Listener: l}
+
+	// Accept connections and never send anything
+	go func() {
+		for {
+			conn, err := silent.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	replica := openTestWAL(t, t.TempDir(), testOptions())
+	follower := NewFollower(replica, l.Addr().String())
+	follower.SetRetryInterval(10 * time.Millisecond)
+	follower.SetReadTimeout(50 * time.Millisecond)
+	runFollower(t, follower)
+
+	awaitAccepted(t, silent, 2)
+}
+
+func TestFollowerKeepsIdleConnection(t *testing.T) {
+	leader := openTestWAL(t, t.TempDir(), testOptions())
+	replica := openTestWAL(t, t.TempDir(), testOptions())
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	counting := &countingListener{Listener: l}
+	server := NewReplicationServer(leader)
+	server.SetHeartbeatInterval(10 * time.Millisecond)
+	go server.Serve(counting)
+	t.Cleanup(func() { server.Close() })
+
+	follower := NewFollower(replica, l.Addr().String())
+	follower.SetRetryInterval(10 * time.Millisecond)
+	follower.SetReadTimeout(200 * time.Millisecond)
+	runFollower(t, follower)
+
+	writeData(t, leader, "a")
+	awaitReplica(t, leader, replica)
+
+	// Heartbeats keep the idle connection open
+	time.Sleep(500 * time.Millisecond)
+	writeData(t, leader, "b")
+	awaitReplica(t, leader, replica)
+	if got := counting.accepted.Load(); got != 1 {
+		t.Fatalf("%d connections accepted, want 1", got)
+	}
+}
@@ -0,0 +1,377 @@
+package wal
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"time"
+)
+
+var defaultRetentionInterval = time.Minute
+
+// SegmentInfo describes a segment considered for retention.
+type SegmentInfo struct {
+	// ID is the segment ID
+	ID int
+	// Size is the size of the segment in bytes
+	Size int64
+	// FirstLSN is the LSN of the first entry of the segment
+	// it is 0 for an empty segment without header
+	FirstLSN uint64
+	// LastLSN is the LSN of the last entry of the segment
+	// it is FirstLSN-1 for an empty segment
+	LastLSN uint64
+	// CreatedAt is when the segment was created
+	// it is zero for segments without header
+	CreatedAt time.Time
+	// ModTime is when the segment was last modified
+	// it is zero if the segment manager does not
+	// implement SegmentModTimer
+	ModTime time.Time
+}
+
+// RetentionPolicy decides which segments can be deleted.
+//
+// Policies are evaluated in the background after every rotation and every
+// RetentionInterval. A policy only proposes deletions: the WAL never deletes the
+// current segment nor the segments needed to recover from the latest checkpoint,
+// and reports through OnRetentionBlocked when that keeps it from honoring the
+// policy.
+type RetentionPolicy interface {
+	// Deletable returns how many of the oldest segments can be deleted.
+	// The segments are ordered from oldest to newest and end with the
+	// current segment.
+	Deletable(segments []SegmentInfo) int
+}
+
+// SegmentModTimer is implemented by segment managers that can report when a
+// segment was last modified.
+//
+// MaxAgeRetention uses it to age segments, falling back to the creation time
+// recorded in the header of the next segment.
+type SegmentModTimer interface {
+	// SegmentModTime returns when the segment was last modified.
+	SegmentModTime(id int) (time.Time, error)
+}
+
+// MaxSegmentsRetention keeps at most MaxSegments segments, including the
+// current one. It is the default policy, configured by WALOptions.MaxSegments.
+type MaxSegmentsRetention struct {
+	// MaxSegments is the maximum number of segments to keep
+	// zero or less keeps every segment
+	MaxSegments int
+}
+
+// Deletable returns how many segments exceed MaxSegments.
+func (p MaxSegmentsRetention) Deletable(segments []SegmentInfo) int {
+	if p.MaxSegments <= 0 {
+		return 0
+	}
+	return max(0, len(segments)-p.MaxSegments)
+}
+
+// MaxAgeRetention deletes segments whose newest entry is older than MaxAge.
+//
+// A segment is aged by its modification time when the segment manager
+// implements SegmentModTimer, and otherwise by the creation time of the
+// next segment, when it was sealed.
+type MaxAgeRetention struct {
+	// MaxAge is how long segments are kept after they were last written
+	MaxAge time.Duration
+}
+
+// Deletable returns how many of the oldest segments are older than MaxAge.
+func (p MaxAgeRetention) Deletable(segments []SegmentInfo) int {
+	now := time.Now()
+	for i := 0; i < len(segments)-1; i++ {
+		written := segments[i].ModTime
+		if written.IsZero() {
+			written = segments[i+1].CreatedAt
+		}
+		if written.IsZero() || now.Sub(written) <= p.MaxAge {
+			return i
+		}
+	}
+	return max(0, len(segments)-1)
+}
+
+// MaxBytesRetention deletes the oldest segments while the segments together
+// take more than MaxBytes.
+type MaxBytesRetention struct {
+	// MaxBytes is the maximum total size of the segments in bytes
+	MaxBytes int64
+}
+
+// Deletable returns how many of the oldest segments must be deleted to bring
+// the total size down to MaxBytes.
+func (p MaxBytesRetention) Deletable(segments []SegmentInfo) int {
+	var total int64
+	for _, segment := range segments {
+		total += segment.Size
+	}
+
+	n := 0
+	for n < len(segments) && total > p.MaxBytes {
+		total -= segments[n].Size
+		n++
+	}
+	return n
+}
+
+// WatermarkRetention deletes segments that only hold entries below an LSN the
+// application no longer needs, typically the last LSN durably applied elsewhere.
+type WatermarkRetention struct {
+	// SafeLSN returns the lowest LSN that must be kept
+	// nil keeps every segment
+	SafeLSN func() uint64
+}
+
+// Deletable returns how many of the oldest segments only hold entries below
+// SafeLSN, none if SafeLSN is nil.
+func (p WatermarkRetention) Deletable(segments []SegmentInfo) int {
+	if p.SafeLSN == nil {
+		return 0
+	}
+	safe := p.SafeLSN()
+	for i, segment := range segments {
+		if segment.LastLSN >= safe {
+			return i
+		}
+	}
+	return len(segments)
+}
+
+// AnyRetention combines policies, deleting a segment as soon as any of
+// them allows it.
+type AnyRetention []RetentionPolicy
+
+// Deletable returns the largest number of segments any policy allows to delete.
+func (p AnyRetention) Deletable(segments []SegmentInfo) int {
+	n := 0
+	for _, policy := range p {
+		n = max(n, policy.Deletable(segments))
+	}
+	return n
+}
+
+// checkpointLocation is where the latest checkpoint is stored
+type checkpointLocation struct {
+	// segmentID is the segment holding the checkpoint
+	segmentID int
+	// lsn is the LSN of the checkpoint
+	lsn uint64
+}
+
+// ApplyRetention evaluates the retention policy and deletes the segments it
+// allows to delete.
+//
+// Retention runs in the background after every rotation and every
+// RetentionInterval, ApplyRetention runs it immediately.
+//
+// This method is thread-safe.
+func (w *WAL) ApplyRetention() error {
+	w.retentionMu.Lock()
+	defer w.retentionMu.Unlock()
+
+	w.mu.Lock()
+	segments, err := w.segmentMgr.ListSegments()
+	if err != nil {
+		w.mu.Unlock()
+		return fmt.Errorf("list segments: %w", err)
+	}
+	lastLSN := w.lastLSN
+	w.mu.Unlock()
+
+	checkpoint, err := w.latestCheckpoint(segments)
+	if err != nil {
+		return fmt.Errorf("find latest checkpoint: %w", err)
+	}
+	if len(segments) < 2 {
+		return nil
+	}
+
+	// Segments are only deleted once archived
+	if err := w.archiveSealed(segments[:len(segments)-1]); err != nil {
+		return err
+	}
+
+	infos, err := w.segmentInfos(segments, lastLSN)
+	if err != nil {
+		return err
+	}
+
+	// Never delete the current segment nor the segments
+	// needed to recover from the latest checkpoint
+	wanted := min(w.options.retention().Deletable(infos), len(segments)-1)
+	n := wanted
+	for i := 0; i < wanted; i++ {
+		if checkpoint != nil && segments[i] >= checkpoint.segmentID {
+			n = i
+			w.retentionBlocked(fmt.Errorf("%w: keeping %d segments the retention policy would delete, segment %d is needed to recover from the checkpoint at LSN %d",
+				ErrRetentionBlocked, wanted-n, segments[i], checkpoint.lsn))
+			break
+		}
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, segID := range segments[:n] {
+		if err := w.segmentMgr.DeleteSegment(segID); err != nil {
+			return fmt.Errorf("delete segment %d: %w", segID, err)
+		}
+		w.index.forget(segID)
+	}
+
+	return nil
+}
+
+// segmentInfos describes the segments for the retention policy
+// the last segment is the current one, ending at lastLSN
+func (w *WAL) segmentInfos(segments []int, lastLSN uint64) ([]SegmentInfo, error) {
+	infos := make([]SegmentInfo, len(segments))
+	modTimer, hasModTime := w.segmentMgr.(SegmentModTimer)
+
+	next := lastLSN + 1
+	for i := len(segments) - 1; i >= 0; i-- {
+		segID := segments[i]
+		info := SegmentInfo{ID: segID}
+
+		size, err := w.segmentMgr.CurrentSegmentSize(segID)
+		if err != nil {
+			return nil, err
+		}
+		info.Size = size
+
+		base, ok, header, err := w.index.describe(segID)
+		if err != nil {
+			return nil, fmt.Errorf("read segment %d: %w", segID, err)
+		}
+		if header != nil {
+			info.CreatedAt = header.CreatedAt
+		}
+		info.LastLSN = next - 1
+		if ok {
+			info.FirstLSN = base
+			next = base
+		}
+
+		if hasModTime {
+			modTime, err := modTimer.SegmentModTime(segID)
+			if err != nil {
+				return nil, err
+			}
+			info.ModTime = modTime
+		}
+
+		infos[i] = info
+	}
+
+	return infos, nil
+}
+
+// scheduleRetention wakes up the retention loop
+// it never blocks the write path
+func (w *WAL) scheduleRetention() {
+	select {
+	case w.retentionDue <- struct{}{}:
+	default:
+	}
+}
+
+// retentionLoop applies the retention policy in the background
+// after rotations and at the retention interval
+func (w *WAL) retentionLoop() {
+	defer w.wg.Done()
+
+	ticker := time.NewTicker(w.options.retentionInterval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.retentionDue:
+		case <-ticker.C:
+		case <-w.ctx.Done():
+			return
+		}
+
+		if err := w.ApplyRetention(); err != nil {
+			log.Printf("WAL retention error: %v", err)
+		}
+	}
+}
+
+// retentionBlocked reports that segments could not be deleted
+func (w *WAL) retentionBlocked(err error) {
+	if w.options.OnRetentionBlocked != nil {
+		w.options.OnRetentionBlocked(err)
+		return
+	}
+	log.Printf("Warning: %v", err)
+}
+
+// latestCheckpoint returns where the latest checkpoint is stored, or nil if
+// the WAL holds no checkpoint
+//
+// The location is tracked as checkpoints are written, and found by scanning
+// segments, a snapshot of the segment list, from newest to oldest the first
+// time it is needed after Open. The scan runs without w.mu held so that it
+// never blocks writers.
+// it must be called with w.retentionMu held and w.mu not held
+func (w *WAL) latestCheckpoint(segments []int) (*checkpointLocation, error) {
+	w.mu.Lock()
+	checkpoint, scanned := w.checkpoint, w.checkpointScanned
+	w.mu.Unlock()
+	if scanned {
+		return checkpoint, nil
+	}
+
+	for i := len(segments) - 1; i >= 0; i-- {
+		lsn, found, err := w.lastCheckpointIn(segments[i])
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			checkpoint = &checkpointLocation{segmentID: segments[i], lsn: lsn}
+			break
+		}
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	// A checkpoint written during the scan is newer
+	if !w.checkpointScanned {
+		w.checkpoint = checkpoint
+		w.checkpointScanned = true
+	}
+	return w.checkpoint, nil
+}
+
+// lastCheckpointIn returns the LSN of the last checkpoint in a segment
+// reading stops at the first damaged entry
+func (w *WAL) lastCheckpointIn(segID int) (uint64, bool, error) {
+	reader, entryReader, err := w.source.openAt(segID, 0)
+	if isCorruption(err) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	defer reader.Close()
+
+	var lsn uint64
+	var found bool
+	for {
+		entry, err := entryReader.ReadVerifiedEntry()
+		if err == io.EOF || isCorruption(err) {
+			return lsn, found, nil
+		}
+		if err != nil {
+			return 0, false, fmt.Errorf("read segment %d: %w", segID, err)
+		}
+		if entry.GetIsCheckpoint() {
+			lsn, found = entry.LogSequenceNumber, true
+		}
+	}
+}
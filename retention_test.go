@@ -0,0 +1,190 @@
+package wal
+
+import (
+	"errors"
+	"io"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWatermarkRetention(t *testing.T) {
+	segments := []SegmentInfo{
+		{ID: 0, FirstLSN: 1, LastLSN: 10},
+		{ID: 1, FirstLSN: 11, LastLSN: 20},
+		{ID: 2, FirstLSN: 21, LastLSN: 25},
+	}
+
+	tests := []struct {
+		name   string
+		policy WatermarkRetention
+		want   int
+	}{
+		{"nil SafeLSN", WatermarkRetention{}, 0},
+		{"below first segment", WatermarkRetention{SafeLSN: func() uint64 { return 5 }}, 0},
+		{"past first segment", WatermarkRetention{SafeLSN: func() uint64 { return 11 }}, 1},
+		{"past every segment", WatermarkRetention{SafeLSN: func() uint64 { return 100 }}, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.Deletable(segments); got != tt.want {
+				t.Errorf("Deletable() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyRetentionKeepsCheckpointAfterOpen(t *testing.T) {
+	dir := t.TempDir()
+	w := openTestWAL(t, dir, truncateOptions())
+	writeEntries(t, w, 10)
+	checkpointLSN, err := w.WriteCheckpoint([]byte("checkpoint"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeEntries(t, w, 30)
+	w.Close()
+
+	// The checkpoint is found by scanning the segments
+	var blocked []error
+	opts := truncateOptions()
+	opts.MaxSegments = 1
+	opts.OnRetentionBlocked = func(err error) { blocked = append(blocked, err) }
+	w = openTestWAL(t, dir, opts)
+	if err := w.ApplyRetention(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(blocked) == 0 || !errors.Is(blocked[0], ErrRetentionBlocked) {
+		t.Fatalf("OnRetentionBlocked got %v, want an error wrapping ErrRetentionBlocked", blocked)
+	}
+	entries, err := w.ReadFromCheckpoint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) == 0 || entries[0].LogSequenceNumber != checkpointLSN {
+		t.Fatalf("ReadFromCheckpoint() starts at %v, want LSN %d", entries, checkpointLSN)
+	}
+}
+
+// checkpointSegment returns the segment holding the latest checkpoint
+func checkpointSegment(t *testing.T, w *WAL) int {
+	t.Helper()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.checkpoint == nil {
+		t.Fatal("no checkpoint is tracked")
+	}
+	return w.checkpoint.segmentID
+}
+
+func TestApplyRetentionFollowsLatestCheckpoint(t *testing.T) {
+	opts := truncateOptions()
+	opts.MaxSegments = 1
+	w := openTestWAL(t, t.TempDir(), opts)
+
+	for range 2 {
+		writeEntries(t, w, 20)
+		if _, err := w.WriteCheckpoint([]byte("checkpoint")); err != nil {
+			t.Fatal(err)
+		}
+		writeEntries(t, w, 20)
+		if err := w.ApplyRetention(); err != nil {
+			t.Fatal(err)
+		}
+
+		// Segments before the checkpoint are deleted, later ones kept
+		segments, err := w.segmentMgr.ListSegments()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := checkpointSegment(t, w); segments[0] != want || len(segments) < 2 {
+			t.Fatalf("segments = %v, want segment %d to the current one", segments, want)
+		}
+	}
+}
+
+// blockingSegmentManager is a FileSegmentManager whose OpenSegment
+// blocks while block is set
+type blockingSegmentManager struct {
+	*FileSegmentManager
+	// mu guards block
+	mu sync.Mutex
+	// block is closed to release OpenSegment
+	block chan struct{}
+	// opened is signaled when OpenSegment blocks
+	opened chan struct{}
+}
+
+func (m *blockingSegmentManager) OpenSegment(id int) (io.ReadCloser, error) {
+	m.mu.Lock()
+	block := m.block
+	m.mu.Unlock()
+
+	if block != nil {
+		select {
+		case m.opened <- struct{}{}:
+		default:
+		}
+		<-block
+	}
+	return m.FileSegmentManager.OpenSegment(id)
+}
+
+func TestApplyRetentionScansWithoutBlockingWriters(t *testing.T) {
+	dir := t.TempDir()
+	w := openTestWAL(t, dir, truncateOptions())
+	writeEntries(t, w, 30)
+	w.Close()
+
+	fsm, err := NewFileSegmentManager(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	segmentMgr := &blockingSegmentManager{FileSegmentManager: fsm, opened: make(chan struct{}, 1)}
+	opts := truncateOptions()
+	opts.MaxSegments = 2
+	w, err = Open(segmentMgr, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	block := make(chan struct{})
+	segmentMgr.mu.Lock()
+	segmentMgr.block = block
+	segmentMgr.mu.Unlock()
+
+	done := make(chan error)
+	go func() { done <- w.ApplyRetention() }()
+	<-segmentMgr.opened
+
+	// The checkpoint scan is reading a segment
+	written := make(chan error)
+	go func() {
+		_, err := w.WriteEntry([]byte("entry"))
+		written <- err
+	}()
+	select {
+	case err := <-written:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("WriteEntry() blocked behind the checkpoint scan")
+	}
+
+	segmentMgr.mu.Lock()
+	segmentMgr.block = nil
+	segmentMgr.mu.Unlock()
+	close(block)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if got := readLSNs(t, w); !slices.Equal(got, lsnRange(got[0], 31)) {
+		t.Fatalf("LSNs = %v, want a contiguous log ending at 31", got)
+	}
+}
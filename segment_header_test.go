@@ -0,0 +1,109 @@
+package wal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"slices"
+	"testing"
+)
+
+// legacySegment returns a segment without header holding entries
+// with the given LSNs, in length-prefixed records
+func legacySegment(t *testing.T, lsns ...uint64) []byte {
+	t.Helper()
+
+	var buf []byte
+	for _, lsn := range lsns {
+		data, err := Marshal(NewEntryWithChecksum(lsn, []byte("legacy"), ChecksumIEEE))
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(len(data)))
+		buf = append(buf, data...)
+	}
+	return buf
+}
+
+func TestSegmentHeaderRoundTrip(t *testing.T) {
+	header := newSegmentHeader(42, ChecksumXXHash64)
+	header.Flags = SegmentFlagEncrypted
+	header.KeyID = 7
+
+	data, err := header.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != SegmentHeaderSize {
+		t.Fatalf("header is %d bytes, want %d", len(data), SegmentHeaderSize)
+	}
+
+	got, err := ReadSegmentHeader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Version != SegmentFormatVersion || got.Flags != header.Flags || got.Checksum != header.Checksum ||
+		got.BaseLSN != 42 || got.KeyID != 7 || !got.CreatedAt.Equal(header.CreatedAt) {
+		t.Fatalf("ReadSegmentHeader() = %+v, want %+v", got, header)
+	}
+}
+
+func TestSegmentHeaderRejectsInvalidHeaders(t *testing.T) {
+	data, err := newSegmentHeader(1, ChecksumCRC32C).MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Damage the creation time, covered by the header CRC
+	damaged := slices.Clone(data)
+	damaged[20] ^= 0xff
+	var header SegmentHeader
+	if err := header.UnmarshalBinary(damaged); !errors.Is(err, ErrInvalidSegmentHeader) {
+		t.Fatalf("UnmarshalBinary() error = %v, want ErrInvalidSegmentHeader", err)
+	}
+	if err := header.UnmarshalBinary(data[:32]); !errors.Is(err, ErrInvalidSegmentHeader) {
+		t.Fatalf("UnmarshalBinary() error = %v on a short header, want ErrInvalidSegmentHeader", err)
+	}
+
+	future := newSegmentHeader(1, ChecksumCRC32C)
+	future.Version = SegmentFormatVersion + 1
+	if data, err = future.MarshalBinary(); err != nil {
+		t.Fatal(err)
+	}
+	if err := header.UnmarshalBinary(data); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Fatalf("UnmarshalBinary() error = %v, want ErrUnsupportedFormat", err)
+	}
+}
+
+func TestReadSegmentHeaderOfLegacySegment(t *testing.T) {
+	if _, err := ReadSegmentHeader(bytes.NewReader(legacySegment(t, 1, 2))); !errors.Is(err, ErrNoSegmentHeader) {
+		t.Fatalf("ReadSegmentHeader() error = %v, want ErrNoSegmentHeader", err)
+	}
+}
+
+func TestOpenLegacySegment(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(segmentPath(dir, 0), legacySegment(t, 1, 2, 3), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	w := openTestWAL(t, dir, testOptions())
+	if got := w.LastLSN(); got != 3 {
+		t.Fatalf("LastLSN() = %d, want 3", got)
+	}
+
+	// A batch is never appended to a legacy segment
+	var batch Batch
+	batch.Add([]byte("a"))
+	batch.Add([]byte("b"))
+	if lsn, err := w.WriteBatch(&batch); err != nil || lsn != 4 {
+		t.Fatalf("WriteBatch() = %d, %v, want 4", lsn, err)
+	}
+	if got := readLSNs(t, w); !slices.Equal(got, lsnRange(1, 5)) {
+		t.Fatalf("LSNs = %v, want 1 to 5", got)
+	}
+	if header := segmentHeader(t, dir, 1); header.BaseLSN != 4 {
+		t.Fatalf("segment 1 starts at LSN %d, want 4", header.BaseLSN)
+	}
+}
@@ -1,15 +1,21 @@
 package wal
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	sync "sync"
+	"time"
 )
 
 var segmentPrefix = "segment-"
 
+var metaPrefix = "meta-"
+
+var lockName = "lock"
+
 // SegmentManager handles segment file operations for the WAL.
 //
 // SegmentManager provides an abstraction for managing the individual segment
@@ -37,11 +43,21 @@ type SegmentManager interface {
 //
 // Segments are stored as files named "segment-N" where N is the segment ID.
 // FileSegmentManager is safe for concurrent use.
+//
+// The first write takes an exclusive advisory lock on the "lock" file of the
+// directory, so that two writers never append to the same segments. Writing
+// to a directory locked by another FileSegmentManager, in this process or
+// another, fails with an error wrapping ErrLocked. The lock is held until
+// Close and is released by the operating system if the process dies. Platforms
+// without flock, such as Windows, do not lock the directory.
 type FileSegmentManager struct {
 	// directory is the directory to store the segments
 	directory string
 	// mu is the mutex to protect the segment files
 	mu sync.RWMutex
+	// lock is the open lock file of the directory
+	// it is nil until the first write
+	lock *os.File
 }
 
 // NewFileSegmentManager creates a new FileSegmentManager for the given directory.
@@ -63,6 +79,10 @@ func (fsm *FileSegmentManager) CreateSegment(id int) (io.WriteCloser, error) {
 	fsm.mu.Lock()
 	defer fsm.mu.Unlock()
 
+	if err := fsm.lockDir(); err != nil {
+		return nil, err
+	}
+
 	path := filepath.Join(fsm.directory, fmt.Sprintf("%s%d", segmentPrefix, id))
 	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
 	if err != nil {
@@ -87,12 +107,19 @@ func (fsm *FileSegmentManager) OpenSegment(id int) (io.ReadCloser, error) {
 // ListSegments returns all segment IDs in ascending order.
 //
 // Segments are discovered by globbing for files matching "segment-*" in the
-// directory and extracting the numeric IDs.
+// directory and extracting the numeric IDs. Files with anything after the ID,
+// such as "segment-3.tmp", are ignored.
 func (fsm *FileSegmentManager) ListSegments() ([]int, error) {
 	fsm.mu.RLock()
 	defer fsm.mu.RUnlock()
 
-	pattern := filepath.Join(fsm.directory, segmentPrefix+"*")
+	return listSegmentFiles(fsm.directory)
+}
+
+// listSegmentFiles returns the IDs of the segment files
+// in a directory in ascending order
+func listSegmentFiles(directory string) ([]int, error) {
+	pattern := filepath.Join(directory, segmentPrefix+"*")
 	matches, err := filepath.Glob(pattern)
 	if err != nil {
 		return nil, fmt.Errorf("list segments: %w", err)
@@ -101,10 +128,15 @@ func (fsm *FileSegmentManager) ListSegments() ([]int, error) {
 	ids := make([]int, 0, len(matches))
 	for _, match := range matches {
 		var id int
-		_, err := fmt.Sscanf(filepath.Base(match), segmentPrefix+"%d", &id)
+		name := filepath.Base(match)
+		_, err := fmt.Sscanf(name, segmentPrefix+"%d", &id)
 		if err != nil {
 			continue
 		}
+		// Skip other files sharing the prefix, such as temporary files
+		if name != fmt.Sprintf("%s%d", segmentPrefix, id) {
+			continue
+		}
 		ids = append(ids, id)
 	}
 
@@ -125,6 +157,10 @@ func (fsm *FileSegmentManager) DeleteSegment(id int) error {
 	fsm.mu.Lock()
 	defer fsm.mu.Unlock()
 
+	if err := fsm.lockDir(); err != nil {
+		return err
+	}
+
 	path := filepath.Join(fsm.directory, fmt.Sprintf("%s%d", segmentPrefix, id))
 	if err := os.Remove(path); err != nil {
 		return fmt.Errorf("delete segment %d: %w", id, err)
@@ -144,3 +180,200 @@ func (fsm *FileSegmentManager) CurrentSegmentSize(id int) (int64, error) {
 	}
 	return info.Size(), nil
 }
+
+// SegmentModTime returns when the segment file was last modified.
+func (fsm *FileSegmentManager) SegmentModTime(id int) (time.Time, error) {
+	fsm.mu.RLock()
+	defer fsm.mu.RUnlock()
+
+	path := filepath.Join(fsm.directory, fmt.Sprintf("%s%d", segmentPrefix, id))
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("stat segment %d: %w", id, err)
+	}
+	return info.ModTime(), nil
+}
+
+// TruncateSegment truncates the segment file to the given size and syncs it.
+func (fsm *FileSegmentManager) TruncateSegment(id int, size int64) error {
+	fsm.mu.Lock()
+	defer fsm.mu.Unlock()
+
+	if err := fsm.lockDir(); err != nil {
+		return err
+	}
+
+	path := filepath.Join(fsm.directory, fmt.Sprintf("%s%d", segmentPrefix, id))
+	file, err := os.OpenFile(path, os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("truncate segment %d: %w", id, err)
+	}
+	defer file.Close()
+
+	if err := file.Truncate(size); err != nil {
+		return fmt.Errorf("truncate segment %d: %w", id, err)
+	}
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("sync segment %d: %w", id, err)
+	}
+	return nil
+}
+
+// LoadMeta returns the value stored under key, or nil if there is none.
+//
+// Values are stored in files named "meta-<key>" next to the segments.
+func (fsm *FileSegmentManager) LoadMeta(key string) ([]byte, error) {
+	fsm.mu.RLock()
+	defer fsm.mu.RUnlock()
+
+	value, err := os.ReadFile(filepath.Join(fsm.directory, metaPrefix+key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("load meta %q: %w", key, err)
+	}
+	return value, nil
+}
+
+// StoreMeta atomically replaces the value stored under key, a nil value
+// removes it.
+//
+// The value is written to a temporary file that is synced and renamed over
+// the previous one, and the directory is synced so that the change survives
+// a crash.
+func (fsm *FileSegmentManager) StoreMeta(key string, value []byte) error {
+	fsm.mu.Lock()
+	defer fsm.mu.Unlock()
+
+	if err := fsm.lockDir(); err != nil {
+		return err
+	}
+
+	path := filepath.Join(fsm.directory, metaPrefix+key)
+	if value == nil {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("remove meta %q: %w", key, err)
+		}
+		return syncDir(fsm.directory)
+	}
+
+	tmp := path + ".tmp"
+	if err := writeFileSync(tmp, value); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("store meta %q: %w", key, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("store meta %q: %w", key, err)
+	}
+	return syncDir(fsm.directory)
+}
+
+// Close releases the lock on the directory.
+//
+// The lock is taken again on the next write.
+func (fsm *FileSegmentManager) Close() error {
+	fsm.mu.Lock()
+	defer fsm.mu.Unlock()
+
+	if fsm.lock == nil {
+		return nil
+	}
+	err := fsm.lock.Close()
+	fsm.lock = nil
+	return err
+}
+
+// lockDir takes the lock on the directory unless it is held
+// it must be called with fsm.mu held
+func (fsm *FileSegmentManager) lockDir() error {
+	if fsm.lock != nil {
+		return nil
+	}
+
+	path := filepath.Join(fsm.directory, lockName)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return fmt.Errorf("open lock file: %w", err)
+	}
+	if err := lockFile(file); err != nil {
+		file.Close()
+		if errors.Is(err, ErrLocked) {
+			return fmt.Errorf("%w: %s", ErrLocked, fsm.directory)
+		}
+		return fmt.Errorf("lock %s: %w", fsm.directory, err)
+	}
+
+	fsm.lock = file
+	return nil
+}
+
+// writeFileSync writes a file and syncs it to disk
+func writeFileSync(path string, data []byte) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// syncDir syncs a directory so that renames and removals in it are durable
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open directory: %w", err)
+	}
+	defer dir.Close()
+
+	if err := dir.Sync(); err != nil {
+		return fmt.Errorf("sync directory: %w", err)
+	}
+	return nil
+}
+
+// segmentSource opens segments of a SegmentManager for reading
+// with the WAL read options applied
+type segmentSource struct {
+	// segmentMgr is the segment manager to read from
+	segmentMgr SegmentManager
+	// maxRecordSize is the largest record accepted
+	maxRecordSize int
+	// keys supplies the keys of encrypted segments
+	keys KeyProvider
+}
+
+// newReader creates an entry reader for the segment read by r
+func (s *segmentSource) newReader(r io.Reader, id int) *BinaryEntryReader {
+	entryReader := newSegmentEntryReader(r, id)
+	entryReader.SetMaxRecordSize(s.maxRecordSize)
+	entryReader.SetKeyProvider(s.keys)
+	return entryReader
+}
+
+// openAt opens a segment and positions an entry reader at offset
+//
+// Segments that support io.Seeker are seeked directly, others are read
+// and discarded up to offset.
+func (s *segmentSource) openAt(id int, offset int64) (io.ReadCloser, *BinaryEntryReader, error) {
+	reader, err := s.segmentMgr.OpenSegment(id)
+	if err != nil {
+		return nil, nil, fmt.Errorf("open segment %d: %w", id, err)
+	}
+
+	entryReader := s.newReader(reader, id)
+	if err := entryReader.seek(offset); err != nil {
+		reader.Close()
+		return nil, nil, fmt.Errorf("seek segment %d: %w", id, err)
+	}
+
+	return reader, entryReader, nil
+}
@@ -0,0 +1,223 @@
+package wal
+
+import (
+	"context"
+	"fmt"
+)
+
+// subscriptionBuffer is the number of entries a subscription
+// reads ahead of its consumer
+const subscriptionBuffer = 64
+
+// Subscription delivers the entries of a WAL as they are written.
+//
+// Entries are delivered in LSN order on the channel returned by Entries, which
+// is closed when the subscription ends. A subscription reads at most a few
+// entries ahead of its consumer, so a slow consumer never holds more than that
+// in memory nor slows down writers: it simply falls behind and catches up from
+// the segments.
+type Subscription struct {
+	// entries delivers the entries in LSN order
+	entries chan *WAL_Entry
+	// batches delivers the entries grouped by the record holding
+	// them, in place of entries, for replication
+	batches chan []*WAL_Entry
+	// cancel stops the subscription
+	cancel context.CancelFunc
+	// done is closed once the subscription ended
+	done chan struct{}
+	// err is why the subscription ended
+	// it is set before done is closed
+	err error
+
+	// rewound is whether TruncateBack removed entries since the
+	// subscription last checked, guarded by the mutex of the WAL
+	rewound bool
+	// rewindTo is the lowest LSN TruncateBack truncated back to
+	// since the subscription last checked
+	rewindTo uint64
+}
+
+// Subscribe returns a Subscription delivering every entry with an LSN greater
+// than or equal to fromLSN.
+//
+// Existing entries are replayed first, then new entries are delivered as soon
+// as they are flushed to their segment, by a sync, a rotation or a write with
+// DurabilityFlush or stronger. Waiting for new entries does not poll: the
+// subscription is woken up by the WAL whenever entries are flushed.
+//
+// The subscription ends when ctx is done, when Close is called or after the
+// last entry is delivered once the WAL is closed, in which case Err returns
+// ErrClosed.
+//
+// When TruncateBack removes entries the subscription already delivered, it
+// continues from the first removed LSN: the next entry delivered then has an
+// LSN lower than or equal to the previous one, and consumers must discard the
+// entries they received from that LSN on.
+//
+// This method is thread-safe.
+func (w *WAL) Subscribe(ctx context.Context, fromLSN uint64) *Subscription {
+	sub := &Subscription{entries: make(chan *WAL_Entry, subscriptionBuffer)}
+	return w.subscribe(ctx, sub, fromLSN)
+}
+
+// subscribeBatches returns a Subscription delivering the entries from
+// fromLSN on its batches channel, the entries of a batch written by
+// WriteBatch together
+func (w *WAL) subscribeBatches(ctx context.Context, fromLSN uint64) *Subscription {
+	sub := &Subscription{batches: make(chan []*WAL_Entry, subscriptionBuffer)}
+	return w.subscribe(ctx, sub, fromLSN)
+}
+
+// subscribe starts a subscription delivering the entries from fromLSN
+func (w *WAL) subscribe(ctx context.Context, sub *Subscription, fromLSN uint64) *Subscription {
+	ctx, sub.cancel = context.WithCancel(ctx)
+	sub.done = make(chan struct{})
+
+	w.mu.Lock()
+	w.subscriptions[sub] = struct{}{}
+	w.mu.Unlock()
+
+	go sub.run(ctx, w, max(fromLSN, 1))
+	return sub
+}
+
+// Entries returns the channel delivering the entries.
+//
+// The channel is closed when the subscription ends, Err then reports why.
+func (s *Subscription) Entries() <-chan *WAL_Entry {
+	return s.entries
+}
+
+// Err returns why the subscription ended, or nil while it is running.
+func (s *Subscription) Err() error {
+	select {
+	case <-s.done:
+		return s.err
+	default:
+		return nil
+	}
+}
+
+// Close ends the subscription and waits for it to stop.
+//
+// Close is safe to call multiple times.
+func (s *Subscription) Close() {
+	s.cancel()
+	<-s.done
+}
+
+// run delivers entries until the subscription ends
+func (s *Subscription) run(ctx context.Context, w *WAL, next uint64) {
+	defer close(s.done)
+	defer func() {
+		if s.batches != nil {
+			close(s.batches)
+		} else {
+			close(s.entries)
+		}
+	}()
+	defer s.cancel()
+	defer func() {
+		w.mu.Lock()
+		delete(w.subscriptions, s)
+		w.mu.Unlock()
+	}()
+
+	for {
+		// Capture the notification before reading so that
+		// entries flushed while reading wake us up again
+		w.mu.Lock()
+		flushed := w.flushedLSN
+		notify := w.flushed
+		next = s.rewind(next)
+		w.mu.Unlock()
+
+		closed := false
+		select {
+		case <-w.closed:
+			closed = true
+		default:
+		}
+
+		if next <= flushed || closed {
+			var err error
+			next, err = s.deliver(ctx, w, next)
+			if err != nil && !s.rewoundWhileReading(w) {
+				s.err = err
+				return
+			}
+		}
+
+		if closed {
+			s.err = ErrClosed
+			return
+		}
+
+		select {
+		case <-notify:
+		case <-w.closed:
+		case <-ctx.Done():
+			s.err = ctx.Err()
+			return
+		}
+	}
+}
+
+// rewind returns the LSN to continue from after the entries
+// removed by TruncateBack since the last check
+// it must be called with w.mu held
+func (s *Subscription) rewind(next uint64) uint64 {
+	if !s.rewound {
+		return next
+	}
+	s.rewound = false
+	return min(next, s.rewindTo+1)
+}
+
+// rewoundWhileReading reports whether TruncateBack removed entries
+// since the last check, in which case a read error is expected
+func (s *Subscription) rewoundWhileReading(w *WAL) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return s.rewound
+}
+
+// deliver sends the entries readable from next
+// it returns the LSN to continue from
+func (s *Subscription) deliver(ctx context.Context, w *WAL, next uint64) (uint64, error) {
+	it, err := w.ReadFrom(next)
+	if err != nil {
+		return next, fmt.Errorf("read from LSN %d: %w", next, err)
+	}
+	defer it.Close()
+
+	var batch []*WAL_Entry
+	for it.Next() {
+		entry := it.Entry()
+		if s.batches == nil {
+			select {
+			case s.entries <- entry:
+				next = entry.LogSequenceNumber + 1
+			case <-ctx.Done():
+				return next, ctx.Err()
+			}
+			continue
+		}
+
+		// Only whole batches are delivered
+		batch = append(batch, entry)
+		if it.inBatch() {
+			continue
+		}
+		select {
+		case s.batches <- batch:
+			next = entry.LogSequenceNumber + 1
+			batch = nil
+		case <-ctx.Done():
+			return next, ctx.Err()
+		}
+	}
+	return next, it.Err()
+}
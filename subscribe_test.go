@@ -0,0 +1,122 @@
+package wal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// receive returns the next n entries of a subscription
+func receive(t *testing.T, sub *Subscription, n int) []*WAL_Entry {
+	t.Helper()
+
+	entries := make([]*WAL_Entry, 0, n)
+	timeout := time.After(5 * time.Second)
+	for len(entries) < n {
+		select {
+		case entry, ok := <-sub.Entries():
+			if !ok {
+				t.Fatalf("subscription ended after %d entries: %v", len(entries), sub.Err())
+			}
+			entries = append(entries, entry)
+		case <-timeout:
+			t.Fatalf("received %d entries, want %d", len(entries), n)
+		}
+	}
+	return entries
+}
+
+func TestSubscribe(t *testing.T) {
+	w := openTestWAL(t, t.TempDir(), truncateOptions())
+	writeEntries(t, w, 5)
+
+	sub := w.Subscribe(context.Background(), 3)
+	defer sub.Close()
+	writeEntries(t, w, 5)
+	if err := w.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, entry := range receive(t, sub, 8) {
+		if want := uint64(i + 3); entry.LogSequenceNumber != want {
+			t.Fatalf("entry %d has LSN %d, want %d", i, entry.LogSequenceNumber, want)
+		}
+	}
+}
+
+func TestSubscribeEndsWhenClosed(t *testing.T) {
+	w := openTestWAL(t, t.TempDir(), testOptions())
+	writeEntries(t, w, 3)
+
+	sub := w.Subscribe(context.Background(), 1)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	receive(t, sub, 3)
+	if _, ok := <-sub.Entries(); ok {
+		t.Fatal("subscription delivered an entry after the last one")
+	}
+	if err := sub.Err(); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Err() = %v, want ErrClosed", err)
+	}
+}
+
+func TestSubscribeRewindsAfterTruncateBack(t *testing.T) {
+	w := openTestWAL(t, t.TempDir(), truncateOptions())
+	sub := w.Subscribe(context.Background(), 1)
+	defer sub.Close()
+
+	writeEntries(t, w, 20)
+	if err := w.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	receive(t, sub, 20)
+
+	if err := w.TruncateBack(12); err != nil {
+		t.Fatal(err)
+	}
+	for i := range 3 {
+		if _, err := w.WriteEntry([]byte(fmt.Sprintf("new %d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The subscription continues from the first removed LSN
+	for i, entry := range receive(t, sub, 3) {
+		want := fmt.Sprintf("new %d", i)
+		if entry.LogSequenceNumber != uint64(13+i) || string(entry.Data) != want {
+			t.Fatalf("entry %d = LSN %d %q, want LSN %d %q", i, entry.LogSequenceNumber, entry.Data, 13+i, want)
+		}
+	}
+}
+
+func TestSubscribeKeepsPositionBeforeTruncation(t *testing.T) {
+	w := openTestWAL(t, t.TempDir(), truncateOptions())
+	writeEntries(t, w, 20)
+	if err := w.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	sub := w.Subscribe(context.Background(), 1)
+	defer sub.Close()
+	receive(t, sub, 20)
+
+	// Nothing delivered is removed
+	if err := w.TruncateBack(20); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.WriteEntry([]byte("next")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if entry := receive(t, sub, 1)[0]; entry.LogSequenceNumber != 21 {
+		t.Fatalf("next entry has LSN %d, want 21", entry.LogSequenceNumber)
+	}
+}
@@ -0,0 +1,39 @@
+//go:build linux
+
+package wal
+
+import "syscall"
+
+// rawConner is a helper interface for types that expose their file descriptor
+type rawConner interface {
+	SyscallConn() (syscall.RawConn, error)
+}
+
+// fdatasync syncs file data without forcing a metadata update.
+//
+// Writers that do not expose a file descriptor fall back to Sync.
+func fdatasync(s syncer) error {
+	rc, ok := s.(rawConner)
+	if !ok {
+		return s.Sync()
+	}
+
+	conn, err := rc.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var syncErr error
+	err = conn.Control(func(fd uintptr) {
+		for {
+			syncErr = syscall.Fdatasync(int(fd))
+			if syncErr != syscall.EINTR {
+				return
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return syncErr
+}
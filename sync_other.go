@@ -0,0 +1,8 @@
+//go:build !linux
+
+package wal
+
+// fdatasync falls back to Sync on platforms without fdatasync.
+func fdatasync(s syncer) error {
+	return s.Sync()
+}
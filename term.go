@@ -0,0 +1,173 @@
+package wal
+
+import (
+	"fmt"
+	"iter"
+)
+
+// metaTerm is the meta key holding the current term
+const metaTerm = "term"
+
+// SetTerm raises the current term of the WAL, typically when the writer
+// becomes leader after a failover.
+//
+// Entries written afterwards carry the term, and writes or replicated entries
+// of an older term are rejected with an error wrapping ErrStaleTerm. Returns an
+// error wrapping ErrStaleTerm if term is older than the current term.
+//
+// The term is persisted when the segment manager implements MetaStore, and is
+// otherwise recovered from the last entry on Open.
+//
+// This method is thread-safe.
+func (w *WAL) SetTerm(term uint64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.adoptTerm(term)
+}
+
+// Term returns the current term of the WAL, 0 if no term was ever set.
+//
+// This method is thread-safe.
+func (w *WAL) Term() uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.term
+}
+
+// WriteEntryAtTerm writes a new entry on behalf of a writer of the given term
+// and returns its LSN.
+//
+// A newer term becomes the current term. Returns an error wrapping ErrStaleTerm
+// if term is older than the current term, which fences off a former leader
+// that has not yet noticed it was replaced.
+//
+// This method is thread-safe and can be called concurrently from multiple goroutines.
+func (w *WAL) WriteEntryAtTerm(term uint64, data []byte) (uint64, error) {
+	return w.appendEntry(&WAL_Entry{Data: data, Term: &term})
+}
+
+// TermAt returns the term of the entry with the given LSN, 0 if it was
+// written without a term.
+//
+// Returns an error wrapping ErrEntryNotFound if no entry has the given LSN.
+func (w *WAL) TermAt(lsn uint64) (uint64, error) {
+	entry, err := w.Get(lsn)
+	if err != nil {
+		return 0, err
+	}
+	return entry.GetTerm(), nil
+}
+
+// DivergencePoint returns the last LSN at which two logs hold an entry of the
+// same term before they first disagree, or 0 if they never agree.
+//
+// Since a term only ever has a single writer, two logs holding the same term
+// at an LSN hold the same entries up to it. Entries past the divergence point
+// of the replica must be removed with TruncateBack before it can follow the
+// other log again. LSNs present in only one of the logs, for example because
+// the front of the other was truncated, are skipped.
+//
+//	lsn, err := wal.DivergencePoint(leader.Entries(from), replica.Entries(from))
+func DivergencePoint(a, b iter.Seq2[*WAL_Entry, error]) (uint64, error) {
+	nextA, stopA := iter.Pull2(a)
+	defer stopA()
+	nextB, stopB := iter.Pull2(b)
+	defer stopB()
+
+	var point uint64
+	entryA, errA, okA := nextA()
+	entryB, errB, okB := nextB()
+	for okA && okB {
+		if errA != nil {
+			return 0, errA
+		}
+		if errB != nil {
+			return 0, errB
+		}
+
+		switch lsnA, lsnB := entryA.LogSequenceNumber, entryB.LogSequenceNumber; {
+		case lsnA < lsnB:
+			entryA, errA, okA = nextA()
+		case lsnA > lsnB:
+			entryB, errB, okB = nextB()
+		case entryA.GetTerm() != entryB.GetTerm():
+			return point, nil
+		default:
+			point = lsnA
+			entryA, errA, okA = nextA()
+			entryB, errB, okB = nextB()
+		}
+	}
+
+	// Report errors that ended either log early
+	if okA && errA != nil {
+		return 0, errA
+	}
+	if okB && errB != nil {
+		return 0, errB
+	}
+	return point, nil
+}
+
+// adoptTerm makes term the current term if it is newer
+// it must be called with w.mu held
+func (w *WAL) adoptTerm(term uint64) error {
+	if err := w.checkStale(term); err != nil {
+		return err
+	}
+	if term == w.term {
+		return nil
+	}
+
+	if err := w.storeMetaUint64(metaTerm, term); err != nil {
+		return fmt.Errorf("store term: %w", err)
+	}
+	w.term = term
+	return nil
+}
+
+// checkStale rejects a term older than the current term
+// it must be called with w.mu held
+func (w *WAL) checkStale(term uint64) error {
+	if term < w.term {
+		return fmt.Errorf("%w: term %d is older than the current term %d", ErrStaleTerm, term, w.term)
+	}
+	return nil
+}
+
+// checkTerm rejects an entry of an older term and adopts a newer one
+// entries without a term are accepted
+// it must be called with w.mu held
+func (w *WAL) checkTerm(entry *WAL_Entry) error {
+	if entry.Term == nil {
+		return nil
+	}
+	return w.adoptTerm(*entry.Term)
+}
+
+// stampTerm checks the term of an entry written locally
+// and stamps it with the current term if it has none
+// it must be called with w.mu held
+func (w *WAL) stampTerm(entry *WAL_Entry) error {
+	if entry.Term != nil {
+		return w.checkTerm(entry)
+	}
+	if w.term > 0 {
+		term := w.term
+		entry.Term = &term
+	}
+	return nil
+}
+
+// loadTerm recovers the current term, the newest of the
+// persisted term and the term of the last entry
+func (w *WAL) loadTerm(lastEntry *WAL_Entry) error {
+	term, _, err := w.loadMetaUint64(metaTerm)
+	if err != nil {
+		return fmt.Errorf("load term: %w", err)
+	}
+	w.term = max(term, lastEntry.GetTerm())
+	return nil
+}
@@ -0,0 +1,134 @@
+package wal
+
+import (
+	"errors"
+	"iter"
+	"slices"
+	"testing"
+)
+
+func TestWriteEntryAtTerm(t *testing.T) {
+	dir := t.TempDir()
+	w := openTestWAL(t, dir, testOptions())
+
+	if err := w.SetTerm(2); err != nil {
+		t.Fatal(err)
+	}
+	writeEntries(t, w, 1)
+	if lsn, err := w.WriteEntryAtTerm(3, []byte("leader")); err != nil || lsn != 2 {
+		t.Fatalf("WriteEntryAtTerm(3) = %d, %v, want 2", lsn, err)
+	}
+
+	// A former leader is fenced off without consuming an LSN
+	if _, err := w.WriteEntryAtTerm(2, []byte("stale")); !errors.Is(err, ErrStaleTerm) {
+		t.Fatalf("WriteEntryAtTerm(2) error = %v, want ErrStaleTerm", err)
+	}
+	if err := w.SetTerm(1); !errors.Is(err, ErrStaleTerm) {
+		t.Fatalf("SetTerm(1) error = %v, want ErrStaleTerm", err)
+	}
+	if got := w.LastLSN(); got != 2 {
+		t.Fatalf("LastLSN() = %d, want 2", got)
+	}
+
+	if err := w.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	for lsn, want := range map[uint64]uint64{1: 2, 2: 3} {
+		if got, err := w.TermAt(lsn); err != nil || got != want {
+			t.Fatalf("TermAt(%d) = %d, %v, want %d", lsn, got, err, want)
+		}
+	}
+	if _, err := w.TermAt(3); !errors.Is(err, ErrEntryNotFound) {
+		t.Fatalf("TermAt(3) error = %v, want ErrEntryNotFound", err)
+	}
+}
+
+func TestTermPersistedAcrossReopen(t *testing.T) {
+	dir := t.TempDir()
+	w := openTestWAL(t, dir, testOptions())
+	writeEntries(t, w, 1)
+	if err := w.SetTerm(4); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	// No entry was written in term 4
+	w = openTestWAL(t, dir, testOptions())
+	if got := w.Term(); got != 4 {
+		t.Fatalf("Term() = %d, want 4", got)
+	}
+	if _, err := w.WriteEntryAtTerm(3, []byte("stale")); !errors.Is(err, ErrStaleTerm) {
+		t.Fatalf("WriteEntryAtTerm(3) error = %v, want ErrStaleTerm", err)
+	}
+}
+
+// termLog returns a sequence of entries with the given terms
+// starting at LSN first
+func termLog(first uint64, terms ...uint64) iter.Seq2[*WAL_Entry, error] {
+	return func(yield func(*WAL_Entry, error) bool) {
+		for i, term := range terms {
+			if !yield(termEntry(first+uint64(i), term, "entry"), nil) {
+				return
+			}
+		}
+	}
+}
+
+func TestDivergencePoint(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b iter.Seq2[*WAL_Entry, error]
+		want uint64
+	}{
+		{"identical", termLog(1, 1, 1, 2), termLog(1, 1, 1, 2), 3},
+		{"diverged", termLog(1, 1, 1, 2, 2), termLog(1, 1, 1, 3), 2},
+		{"prefix", termLog(1, 1, 1, 2, 2), termLog(1, 1, 1), 2},
+		{"never agree", termLog(1, 2), termLog(1, 3), 0},
+		{"truncated front", termLog(1, 1, 1, 2, 2), termLog(3, 2, 3), 3},
+		{"empty", termLog(1), termLog(1, 1), 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := DivergencePoint(tt.a, tt.b); err != nil || got != tt.want {
+				t.Fatalf("DivergencePoint() = %d, %v, want %d", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestDivergencePointAfterFailover(t *testing.T) {
+	leader := openTestWAL(t, t.TempDir(), testOptions())
+	replica := openTestWAL(t, t.TempDir(), testOptions())
+
+	// The replica received the first two entries of term 1 and
+	// wrote one of its own, the new leader wrote two in term 2
+	for _, w := range []*WAL{leader, replica} {
+		if err := w.AppendEntries([]*WAL_Entry{termEntry(1, 1, "a"), termEntry(2, 1, "b")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := replica.WriteEntryAtTerm(1, []byte("lost")); err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		if _, err := leader.WriteEntryAtTerm(2, []byte("new")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, w := range []*WAL{leader, replica} {
+		if err := w.Sync(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	point, err := DivergencePoint(leader.Entries(1), replica.Entries(1))
+	if err != nil || point != 2 {
+		t.Fatalf("DivergencePoint() = %d, %v, want 2", point, err)
+	}
+	if err := replica.TruncateBack(point); err != nil {
+		t.Fatal(err)
+	}
+	if got := readLSNs(t, replica); !slices.Equal(got, lsnRange(1, 2)) {
+		t.Fatalf("LSNs = %v, want 1 to 2", got)
+	}
+}
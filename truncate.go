@@ -0,0 +1,393 @@
+package wal
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const (
+	// metaFirstLSN is the meta key holding the LSN set by TruncateFront
+	metaFirstLSN = "first-lsn"
+	// metaTruncateBack is the meta key holding the LSN of a
+	// TruncateBack in progress, it is removed once it completes
+	metaTruncateBack = "truncate-back"
+)
+
+// MetaStore is implemented by segment managers that can persist small values
+// next to the segments.
+//
+// TruncateFront and TruncateBack use it to record where the log was truncated
+// before touching any segment, so that Open can hide truncated entries and
+// complete a truncation interrupted by a crash. StoreMeta must replace values
+// atomically.
+type MetaStore interface {
+	// LoadMeta returns the value stored under key, or nil if there is none.
+	LoadMeta(key string) ([]byte, error)
+	// StoreMeta atomically replaces the value stored under key.
+	// A nil value removes it.
+	StoreMeta(key string, value []byte) error
+}
+
+// TruncateFront removes the entries with an LSN lower than lsn.
+//
+// Segments holding only such entries are deleted, and the entries of the
+// segment holding lsn that precede it are hidden from readers. If lsn is past
+// the end of the log every entry is removed and the next entry written gets
+// lsn, for example after installing a snapshot.
+//
+// The new front is recorded before any segment is deleted, so a crash never
+// brings truncated entries back as long as the segment manager implements
+// MetaStore. Otherwise only whole segments stay removed after Open.
+//
+// This method is thread-safe.
+func (w *WAL) TruncateFront(lsn uint64) error {
+	w.retentionMu.Lock()
+	defer w.retentionMu.Unlock()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.awaitSyncRound()
+	if w.failure != nil {
+		return w.failure
+	}
+	if lsn <= max(w.firstLSN, 1) {
+		return nil
+	}
+
+	if err := w.storeMetaUint64(metaFirstLSN, lsn); err != nil {
+		return fmt.Errorf("store first LSN: %w", err)
+	}
+	w.firstLSN = lsn
+
+	if lsn > w.lastLSN+1 {
+		// Start a new segment at lsn so that the header
+		// records where the log resumes
+		w.lastLSN = lsn - 1
+		if err := w.rotate(); err != nil {
+			return fmt.Errorf("rotate: %w", err)
+		}
+	}
+
+	return w.deleteBefore(lsn)
+}
+
+// TruncateBack removes the entries with an LSN greater than lsn, so that the
+// next entry written gets lsn+1.
+//
+// Segments holding only such entries are deleted and the segment holding lsn
+// is truncated right after it and becomes the current segment, unless
+// encryption is enabled and it is not encrypted with the current key, in which
+// case a new segment is started after it. The entries of
+// a batch are removed together: truncating inside a batch fails with an error
+// wrapping ErrBatchSplit. Truncating before the front of the log fails with an
+// error wrapping ErrLSNOutOfRange.
+//
+// The truncation is recorded before any segment is touched when the segment
+// manager implements MetaStore, and Open completes it after a crash. If a
+// segment cannot be deleted, truncated or reopened, the WAL is left without a
+// segment to append to: TruncateBack and every later write and sync return the
+// error until the WAL is reopened. The segment manager must implement
+// SegmentTruncater, and the Archiver, if any, ArchiveTruncater: the archived
+// copies of the segments from the one holding lsn on are removed first.
+//
+// This method is thread-safe.
+func (w *WAL) TruncateBack(lsn uint64) error {
+	w.retentionMu.Lock()
+	defer w.retentionMu.Unlock()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.awaitSyncRound()
+	if w.failure != nil {
+		return w.failure
+	}
+	if lsn >= w.lastLSN {
+		return nil
+	}
+	if lsn+1 < w.firstLSN {
+		return fmt.Errorf("%w: cannot truncate back to LSN %d before the first LSN %d", ErrLSNOutOfRange, lsn, w.firstLSN)
+	}
+	if _, ok := w.segmentMgr.(SegmentTruncater); !ok {
+		return fmt.Errorf("truncate back: segment manager does not implement SegmentTruncater")
+	}
+	if w.options.Archiver != nil {
+		if _, ok := w.options.Archiver.(ArchiveTruncater); !ok {
+			return fmt.Errorf("truncate back: archiver does not implement ArchiveTruncater")
+		}
+	}
+
+	// Nothing is changed until the truncation is known to be possible
+	plan, err := w.planTruncateBack(lsn)
+	if err != nil {
+		return err
+	}
+	if err := w.storeMetaUint64(metaTruncateBack, lsn); err != nil {
+		return fmt.Errorf("store truncation: %w", err)
+	}
+	if err := w.applyTruncateBack(lsn, plan); err != nil {
+		return w.fail(fmt.Errorf("truncate back to LSN %d, reopen the WAL to recover: %w", lsn, err))
+	}
+	return w.deleteMeta(metaTruncateBack)
+}
+
+// truncateBackPlan describes how to remove the entries after an LSN
+type truncateBackPlan struct {
+	// segments are the segments of the log
+	segments []int
+	// start is the index in segments of the segment to truncate
+	start int
+	// header is the header of the segment to truncate
+	header *SegmentHeader
+	// size is the size to truncate the segment to
+	size int64
+}
+
+// truncateBack removes the entries after lsn
+// it must be called with w.mu held and no group commit in flight
+func (w *WAL) truncateBack(lsn uint64) error {
+	plan, err := w.planTruncateBack(lsn)
+	if err != nil {
+		return err
+	}
+	return w.applyTruncateBack(lsn, plan)
+}
+
+// planTruncateBack finds where to cut the log to remove the entries
+// after lsn, without changing any segment
+// it must be called with w.mu held and no group commit in flight
+func (w *WAL) planTruncateBack(lsn uint64) (*truncateBackPlan, error) {
+	// Entries to keep may still be buffered
+	if err := w.entryWriter.Flush(); err != nil {
+		return nil, fmt.Errorf("flush: %w", err)
+	}
+
+	segments, err := w.segmentMgr.ListSegments()
+	if err != nil {
+		return nil, fmt.Errorf("list segments: %w", err)
+	}
+
+	start, offset, err := w.index.locate(segments, lsn+1)
+	if err != nil {
+		return nil, fmt.Errorf("locate LSN %d: %w", lsn+1, err)
+	}
+
+	header, size, err := w.truncationPoint(segments[start], offset, lsn)
+	if err != nil {
+		return nil, err
+	}
+	return &truncateBackPlan{segments: segments, start: start, header: header, size: size}, nil
+}
+
+// applyTruncateBack cuts the log as planned by planTruncateBack
+// the current segment is closed first, so an error leaves the
+// WAL without a segment to append to
+// it must be called with w.mu held and no group commit in flight
+func (w *WAL) applyTruncateBack(lsn uint64, plan *truncateBackPlan) error {
+	segments, start := plan.segments, plan.start
+	segID := segments[start]
+
+	// Archived copies hold the removed entries
+	if truncater, ok := w.options.Archiver.(ArchiveTruncater); ok {
+		if err := truncater.TruncateArchive(segID); err != nil {
+			return fmt.Errorf("truncate archive: %w", err)
+		}
+	}
+
+	if err := w.currentWriter.Close(); err != nil {
+		return fmt.Errorf("close current segment: %w", err)
+	}
+
+	// Delete the later segments newest first, so that a crash
+	// never leaves a gap in the log
+	for i := len(segments) - 1; i > start; i-- {
+		if err := w.segmentMgr.DeleteSegment(segments[i]); err != nil {
+			return fmt.Errorf("delete segment %d: %w", segments[i], err)
+		}
+		w.index.forget(segments[i])
+	}
+
+	if err := w.segmentMgr.(SegmentTruncater).TruncateSegment(segID, plan.size); err != nil {
+		return fmt.Errorf("truncate segment %d: %w", segID, err)
+	}
+	w.index.forget(segID)
+	w.archivedThrough = min(w.archivedThrough, segID-1)
+
+	// Resume appending to the truncated segment
+	writer, err := w.segmentMgr.CreateSegment(segID)
+	if err != nil {
+		return fmt.Errorf("open segment %d: %w", segID, err)
+	}
+	w.currentSegment = segID
+	w.currentWriter = writer
+	w.entryWriter = w.newEntryWriter(writer)
+	if err := w.entryWriter.setHeader(plan.header); err != nil {
+		return err
+	}
+
+	w.lastLSN = lsn
+	w.syncedLSN = min(w.syncedLSN, lsn)
+	w.flushedLSN = min(w.flushedLSN, lsn)
+	w.rewindSubscriptions(lsn)
+	if w.checkpoint != nil && w.checkpoint.lsn > lsn {
+		// An earlier checkpoint may still be in the log
+		w.checkpoint = nil
+		w.checkpointScanned = false
+	}
+
+	// A legacy segment truncated to nothing gets a header
+	if err := w.initSegment(); err != nil {
+		return err
+	}
+
+	// Never append to a segment of an older key or
+	// an unencrypted one when encryption is enabled
+	return w.rotateForEncryption()
+}
+
+// truncationPoint returns the header of a segment and the size to
+// truncate it to so that it ends with the entry at lsn
+// reading starts at offset, the start of an entry at or before lsn
+func (w *WAL) truncationPoint(segID int, offset int64, lsn uint64) (*SegmentHeader, int64, error) {
+	reader, entryReader, err := w.source.openAt(segID, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer reader.Close()
+
+	header := entryReader.header
+	if header != nil && header.BaseLSN > lsn+1 {
+		return nil, 0, fmt.Errorf("%w: segment %d starts at LSN %d, after LSN %d", ErrLSNOutOfRange, segID, header.BaseLSN, lsn+1)
+	}
+
+	size := entryReader.Offset()
+	for {
+		entry, err := entryReader.ReadVerifiedEntry()
+		if err == io.EOF || isCorruption(err) {
+			return header, size, nil
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("read segment %d: %w", segID, err)
+		}
+
+		if entry.LogSequenceNumber <= lsn {
+			size = entryReader.Offset()
+			continue
+		}
+		if entryReader.entryOffset < size {
+			// The entry shares its frame with an entry to keep
+			return nil, 0, fmt.Errorf("%w: LSN %d and %d were written by the same batch", ErrBatchSplit, lsn, lsn+1)
+		}
+		return header, size, nil
+	}
+}
+
+// deleteBefore deletes the segments holding only entries before lsn
+// oldest first, the current segment is never deleted
+func (w *WAL) deleteBefore(lsn uint64) error {
+	segments, err := w.segmentMgr.ListSegments()
+	if err != nil {
+		return fmt.Errorf("list segments: %w", err)
+	}
+
+	infos, err := w.segmentInfos(segments, w.lastLSN)
+	if err != nil {
+		return err
+	}
+
+	n := 0
+	for n < len(infos)-1 && infos[n].LastLSN < lsn {
+		n++
+	}
+	if err := w.archiveSealed(segments[:n]); err != nil {
+		return err
+	}
+
+	for i := 0; i < n; i++ {
+		if err := w.segmentMgr.DeleteSegment(infos[i].ID); err != nil {
+			return fmt.Errorf("delete segment %d: %w", infos[i].ID, err)
+		}
+		w.index.forget(infos[i].ID)
+
+		if w.checkpoint != nil && w.checkpoint.segmentID == infos[i].ID {
+			// No later segment holds a checkpoint
+			w.checkpoint = nil
+		}
+	}
+
+	return nil
+}
+
+// recoverTruncation loads the front of the log and completes a
+// truncation interrupted by a crash, it is called by Open
+func (w *WAL) recoverTruncation() error {
+	firstLSN, _, err := w.loadMetaUint64(metaFirstLSN)
+	if err != nil {
+		return fmt.Errorf("load first LSN: %w", err)
+	}
+	w.firstLSN = firstLSN
+
+	backLSN, pending, err := w.loadMetaUint64(metaTruncateBack)
+	if err != nil {
+		return fmt.Errorf("load truncation: %w", err)
+	}
+	if pending {
+		if backLSN < w.lastLSN {
+			if err := w.truncateBack(backLSN); err != nil {
+				return fmt.Errorf("complete truncation to LSN %d: %w", backLSN, err)
+			}
+		}
+		if err := w.deleteMeta(metaTruncateBack); err != nil {
+			return err
+		}
+	}
+
+	if firstLSN == 0 {
+		return nil
+	}
+	if w.lastLSN+1 < firstLSN {
+		// Crashed before TruncateFront started a new segment
+		w.lastLSN = firstLSN - 1
+	}
+	return w.deleteBefore(firstLSN)
+}
+
+// loadMetaUint64 loads an LSN or a term from the meta store
+// ok is false if none is stored or there is no meta store
+func (w *WAL) loadMetaUint64(key string) (value uint64, ok bool, err error) {
+	store, isStore := w.segmentMgr.(MetaStore)
+	if !isStore {
+		return 0, false, nil
+	}
+
+	data, err := store.LoadMeta(key)
+	if err != nil || data == nil {
+		return 0, false, err
+	}
+	if len(data) != 8 {
+		return 0, false, fmt.Errorf("%w: meta %q holds %d bytes", ErrCorruptEntry, key, len(data))
+	}
+	return binary.LittleEndian.Uint64(data), true, nil
+}
+
+// storeMetaUint64 stores an LSN or a term in the meta store
+// it does nothing if there is no meta store
+func (w *WAL) storeMetaUint64(key string, value uint64) error {
+	store, ok := w.segmentMgr.(MetaStore)
+	if !ok {
+		return nil
+	}
+	return store.StoreMeta(key, binary.LittleEndian.AppendUint64(nil, value))
+}
+
+// deleteMeta removes a value from the meta store
+// it does nothing if there is no meta store
+func (w *WAL) deleteMeta(key string) error {
+	store, ok := w.segmentMgr.(MetaStore)
+	if !ok {
+		return nil
+	}
+	return store.StoreMeta(key, nil)
+}
@@ -0,0 +1,213 @@
+package wal
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// truncateOptions returns options rotating every few entries
+func truncateOptions() WALOptions {
+	opts := testOptions()
+	opts.MaxSegmentSize = 200
+	return opts
+}
+
+// lsnRange returns the LSNs from first to last
+func lsnRange(first, last uint64) []uint64 {
+	var lsns []uint64
+	for lsn := first; lsn <= last; lsn++ {
+		lsns = append(lsns, lsn)
+	}
+	return lsns
+}
+
+func TestTruncateFront(t *testing.T) {
+	dir := t.TempDir()
+	w := openTestWAL(t, dir, truncateOptions())
+	writeEntries(t, w, 50)
+
+	if err := w.TruncateFront(23); err != nil {
+		t.Fatal(err)
+	}
+	if got := readLSNs(t, w); !slices.Equal(got, lsnRange(23, 50)) {
+		t.Fatalf("LSNs = %v, want 23 to 50", got)
+	}
+	if _, err := w.Get(5); !errors.Is(err, ErrEntryNotFound) {
+		t.Fatalf("Get(5) error = %v, want ErrEntryNotFound", err)
+	}
+	w.Close()
+
+	w = openTestWAL(t, dir, truncateOptions())
+	if got := readLSNs(t, w); !slices.Equal(got, lsnRange(23, 50)) {
+		t.Fatalf("LSNs after reopen = %v, want 23 to 50", got)
+	}
+}
+
+func TestTruncateFrontPastEnd(t *testing.T) {
+	dir := t.TempDir()
+	w := openTestWAL(t, dir, truncateOptions())
+	writeEntries(t, w, 10)
+
+	if err := w.TruncateFront(100); err != nil {
+		t.Fatal(err)
+	}
+	if got := readLSNs(t, w); len(got) != 0 {
+		t.Fatalf("LSNs = %v, want none", got)
+	}
+	if lsn, err := w.WriteEntry([]byte("a")); err != nil || lsn != 100 {
+		t.Fatalf("WriteEntry() = %d, %v, want 100", lsn, err)
+	}
+	w.Close()
+
+	w = openTestWAL(t, dir, truncateOptions())
+	if got := readLSNs(t, w); !slices.Equal(got, []uint64{100}) {
+		t.Fatalf("LSNs after reopen = %v, want [100]", got)
+	}
+}
+
+func TestTruncateFrontCrashRecovery(t *testing.T) {
+	dir := t.TempDir()
+	w, segmentMgr := openFaultyWAL(t, dir, truncateOptions())
+	writeEntries(t, w, 50)
+	if err := w.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Crash after the truncation is recorded, before any segment is deleted
+	segmentMgr.failDelete.Store(true)
+	if err := w.TruncateFront(23); !errors.Is(err, errInjected) {
+		t.Fatalf("TruncateFront() error = %v, want the injected failure", err)
+	}
+	crash(w)
+
+	w = openTestWAL(t, dir, truncateOptions())
+	if got := readLSNs(t, w); !slices.Equal(got, lsnRange(23, 50)) {
+		t.Fatalf("LSNs = %v, want 23 to 50", got)
+	}
+	if _, err := os.Stat(segmentPath(dir, 0)); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("segment 0 was not deleted: %v", err)
+	}
+}
+
+func TestTruncateBack(t *testing.T) {
+	dir := t.TempDir()
+	w := openTestWAL(t, dir, truncateOptions())
+	writeEntries(t, w, 50)
+
+	if err := w.TruncateBack(17); err != nil {
+		t.Fatal(err)
+	}
+	if got := readLSNs(t, w); !slices.Equal(got, lsnRange(1, 17)) {
+		t.Fatalf("LSNs = %v, want 1 to 17", got)
+	}
+	if lsn, err := w.WriteEntry([]byte("a")); err != nil || lsn != 18 {
+		t.Fatalf("WriteEntry() = %d, %v, want 18", lsn, err)
+	}
+	w.Close()
+
+	w = openTestWAL(t, dir, truncateOptions())
+	if got := readLSNs(t, w); !slices.Equal(got, lsnRange(1, 18)) {
+		t.Fatalf("LSNs after reopen = %v, want 1 to 18", got)
+	}
+}
+
+func TestTruncateBackRejectsSplittingBatch(t *testing.T) {
+	dir := t.TempDir()
+	w := openTestWAL(t, dir, truncateOptions())
+
+	var batch Batch
+	batch.Add([]byte("a"))
+	batch.Add([]byte("b"))
+	if _, err := w.WriteBatch(&batch); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.TruncateBack(1); !errors.Is(err, ErrBatchSplit) {
+		t.Fatalf("TruncateBack(1) error = %v, want ErrBatchSplit", err)
+	}
+	// The rejected truncation changed nothing
+	if got := readLSNs(t, w); !slices.Equal(got, lsnRange(1, 2)) {
+		t.Fatalf("LSNs = %v, want 1 to 2", got)
+	}
+	w.Close()
+
+	// No truncation is left for Open to complete
+	w = openTestWAL(t, dir, truncateOptions())
+	if got := readLSNs(t, w); !slices.Equal(got, lsnRange(1, 2)) {
+		t.Fatalf("LSNs after reopen = %v, want 1 to 2", got)
+	}
+	if err := w.TruncateBack(0); err != nil {
+		t.Fatal(err)
+	}
+	if got := w.LastLSN(); got != 0 {
+		t.Fatalf("LastLSN() = %d, want 0", got)
+	}
+}
+
+func TestTruncateBackFailureIsSticky(t *testing.T) {
+	dir := t.TempDir()
+	w, segmentMgr := openFaultyWAL(t, dir, truncateOptions())
+	writeEntries(t, w, 50)
+
+	segmentMgr.failDelete.Store(true)
+	if err := w.TruncateBack(5); !errors.Is(err, errInjected) {
+		t.Fatalf("TruncateBack(5) error = %v, want the injected failure", err)
+	}
+	segmentMgr.failDelete.Store(false)
+
+	// The WAL reports the failed truncation, not a closed segment
+	if _, err := w.WriteEntry([]byte("a")); !errors.Is(err, errInjected) {
+		t.Fatalf("WriteEntry() error = %v, want the injected failure", err)
+	}
+	if err := w.Sync(); !errors.Is(err, errInjected) {
+		t.Fatalf("Sync() error = %v, want the injected failure", err)
+	}
+	if err := w.TruncateBack(5); !errors.Is(err, errInjected) {
+		t.Fatalf("TruncateBack(5) error = %v, want the injected failure", err)
+	}
+	w.Close()
+
+	// Open completes the truncation
+	w = openTestWAL(t, dir, truncateOptions())
+	if got := readLSNs(t, w); !slices.Equal(got, lsnRange(1, 5)) {
+		t.Fatalf("LSNs after reopen = %v, want 1 to 5", got)
+	}
+	if lsn, err := w.WriteEntry([]byte("a")); err != nil || lsn != 6 {
+		t.Fatalf("WriteEntry() = %d, %v, want 6", lsn, err)
+	}
+}
+
+func TestTruncateBackCrashRecovery(t *testing.T) {
+	for _, lsn := range []uint64{0, 17} {
+		dir := t.TempDir()
+		w, segmentMgr := openFaultyWAL(t, dir, truncateOptions())
+		writeEntries(t, w, 50)
+		if err := w.Sync(); err != nil {
+			t.Fatal(err)
+		}
+
+		// Crash after the later segments are deleted,
+		// before the segment holding lsn is truncated
+		segmentMgr.failTruncate.Store(true)
+		if err := w.TruncateBack(lsn); !errors.Is(err, errInjected) {
+			t.Fatalf("TruncateBack(%d) error = %v, want the injected failure", lsn, err)
+		}
+		crash(w)
+		if _, err := os.Stat(filepath.Join(dir, metaPrefix+metaTruncateBack)); err != nil {
+			t.Fatalf("TruncateBack(%d) recorded no intent: %v", lsn, err)
+		}
+
+		w = openTestWAL(t, dir, truncateOptions())
+		if got := readLSNs(t, w); !slices.Equal(got, lsnRange(1, lsn)) {
+			t.Fatalf("LSNs after TruncateBack(%d) = %v, want 1 to %d", lsn, got, lsn)
+		}
+		if _, err := os.Stat(filepath.Join(dir, metaPrefix+metaTruncateBack)); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("intent of TruncateBack(%d) was not cleared: %v", lsn, err)
+		}
+		if next, err := w.WriteEntry([]byte("a")); err != nil || next != lsn+1 {
+			t.Fatalf("WriteEntry() = %d, %v, want %d", next, err, lsn+1)
+		}
+	}
+}
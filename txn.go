@@ -0,0 +1,198 @@
+package wal
+
+import (
+	"fmt"
+	"iter"
+)
+
+// Txn is a transaction grouping entries that are applied only if it commits.
+//
+// Entries of a transaction interleave with entries of other writers and
+// transactions in the log. Readers that filter with Committed yield them only
+// once the commit marker is read, and drop them if the transaction aborted or
+// never finished, for example because of a crash.
+//
+// A Txn is not safe for concurrent use, but any number of transactions can be
+// open on the same WAL.
+type Txn struct {
+	// w is the WAL the transaction writes to
+	w *WAL
+	// id is the transaction ID, the LSN of its begin marker
+	id uint64
+	// done is whether the transaction was committed or aborted
+	done bool
+}
+
+// BeginTxn starts a transaction by writing its begin marker.
+//
+// The transaction ID is the LSN of the begin marker, so it is unique within
+// the WAL across restarts.
+//
+// This method is thread-safe and can be called concurrently from multiple goroutines.
+func (w *WAL) BeginTxn() (*Txn, error) {
+	txn := &Txn{w: w}
+
+	id, err := w.appendEntry(txn.entry(EntryKind_ENTRY_KIND_TXN_BEGIN, nil))
+	if err != nil {
+		return nil, fmt.Errorf("begin transaction: %w", err)
+	}
+
+	txn.id = id
+	return txn, nil
+}
+
+// ID returns the transaction ID.
+func (t *Txn) ID() uint64 {
+	return t.id
+}
+
+// Write writes an entry as part of the transaction and returns its LSN.
+//
+// Returns ErrTxnDone if the transaction was already committed or aborted.
+func (t *Txn) Write(data []byte) (uint64, error) {
+	if t.done {
+		return 0, ErrTxnDone
+	}
+	return t.w.appendEntry(t.entry(EntryKind_ENTRY_KIND_DATA, data))
+}
+
+// Commit writes the commit marker of the transaction and waits until it is
+// synced to disk according to the sync policy. Returns the LSN of the marker.
+//
+// The transaction is only considered committed once its marker is durable;
+// concurrent commits share a single sync like WriteEntrySync.
+//
+// Returns ErrTxnDone if the transaction was already committed or aborted.
+func (t *Txn) Commit() (uint64, error) {
+	if t.done {
+		return 0, ErrTxnDone
+	}
+
+	lsn, err := t.w.appendEntry(t.entry(EntryKind_ENTRY_KIND_TXN_COMMIT, nil))
+	if err != nil {
+		return 0, fmt.Errorf("commit transaction %d: %w", t.id, err)
+	}
+	t.done = true
+
+	if err := t.w.commit(lsn); err != nil {
+		return 0, fmt.Errorf("commit transaction %d: %w", t.id, err)
+	}
+	return lsn, nil
+}
+
+// Abort writes the abort marker of the transaction, so readers filtering with
+// Committed drop its entries.
+//
+// Returns ErrTxnDone if the transaction was already committed or aborted.
+func (t *Txn) Abort() error {
+	if t.done {
+		return ErrTxnDone
+	}
+
+	if _, err := t.w.appendEntry(t.entry(EntryKind_ENTRY_KIND_TXN_ABORT, nil)); err != nil {
+		return fmt.Errorf("abort transaction %d: %w", t.id, err)
+	}
+	t.done = true
+	return nil
+}
+
+// entry creates an entry of the transaction
+// the begin marker has no ID yet, it becomes its LSN
+func (t *Txn) entry(kind EntryKind, data []byte) *WAL_Entry {
+	entry := &WAL_Entry{
+		Data: data,
+		Kind: kind.Enum(),
+	}
+	if t.id != 0 {
+		id := t.id
+		entry.TxnID = &id
+	}
+	return entry
+}
+
+// txnID returns the transaction an entry belongs to
+// a begin marker belongs to the transaction it starts
+func txnID(entry *WAL_Entry) (uint64, bool) {
+	if entry.GetKind() == EntryKind_ENTRY_KIND_TXN_BEGIN {
+		return entry.LogSequenceNumber, true
+	}
+	if entry.TxnID != nil {
+		return *entry.TxnID, true
+	}
+	return 0, false
+}
+
+// Committed filters a sequence of entries down to the entries that are not
+// part of a transaction and the entries of committed transactions.
+//
+// Entries of a transaction are held back until its commit marker is read and
+// then yielded together, in LSN order, at the position of the commit. Entries of
+// aborted transactions and of transactions still open at the end of the sequence,
+// such as those interrupted by a crash, are dropped. Transaction markers are never
+// yielded.
+//
+// Memory usage is bounded by the entries of the transactions open at any point.
+func Committed(entries iter.Seq2[*WAL_Entry, error]) iter.Seq2[*WAL_Entry, error] {
+	return func(yield func(*WAL_Entry, error) bool) {
+		open := make(map[uint64][]*WAL_Entry)
+
+		for entry, err := range entries {
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+
+			id, ok := txnID(entry)
+			if !ok {
+				if !yield(entry, nil) {
+					return
+				}
+				continue
+			}
+
+			switch entry.GetKind() {
+			case EntryKind_ENTRY_KIND_TXN_BEGIN:
+				open[id] = nil
+			case EntryKind_ENTRY_KIND_TXN_ABORT:
+				delete(open, id)
+			case EntryKind_ENTRY_KIND_TXN_COMMIT:
+				for _, txnEntry := range open[id] {
+					if !yield(txnEntry, nil) {
+						return
+					}
+				}
+				delete(open, id)
+			default:
+				// Entries of a transaction that began before the
+				// start of the sequence are kept as well
+				open[id] = append(open[id], entry)
+			}
+		}
+	}
+}
+
+// CommittedEntries returns an iterator over the committed entries with an LSN
+// greater than or equal to lsn, as filtered by Committed.
+//
+// Transactions that began before lsn only yield their entries at or after lsn.
+func (w *WAL) CommittedEntries(lsn uint64) iter.Seq2[*WAL_Entry, error] {
+	return Committed(w.Entries(lsn))
+}
+
+// ReadCommitted reads all entries from all segments, keeping only entries that
+// are not part of a transaction and entries of committed transactions.
+//
+// It is the transactional counterpart of ReadAll, see Committed for how entries
+// are filtered and ordered.
+func (w *WAL) ReadCommitted() ([]*WAL_Entry, error) {
+	var entries []*WAL_Entry
+
+	for entry, err := range w.CommittedEntries(0) {
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,119 @@
+package wal
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+// committedLSNs returns the LSNs of the committed entries from lsn on
+func committedLSNs(t *testing.T, w *WAL, lsn uint64) []uint64 {
+	t.Helper()
+
+	if err := w.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	var lsns []uint64
+	for entry, err := range w.CommittedEntries(lsn) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		lsns = append(lsns, entry.LogSequenceNumber)
+	}
+	return lsns
+}
+
+// beginTxn starts a transaction
+func beginTxn(t *testing.T, w *WAL) *Txn {
+	t.Helper()
+
+	txn, err := w.BeginTxn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return txn
+}
+
+// writeTxn writes an entry as part of a transaction
+func writeTxn(t *testing.T, txn *Txn) {
+	t.Helper()
+
+	if _, err := txn.Write([]byte("txn entry")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCommitted(t *testing.T) {
+	dir := t.TempDir()
+	w := openTestWAL(t, dir, testOptions())
+
+	// LSN 1 is outside any transaction, the committed transaction writes
+	// 2, 3, 7 and 9, the aborted one 5, 6 and 8, the open one 10 and 11
+	writeEntries(t, w, 1)
+	committed := beginTxn(t, w)
+	writeTxn(t, committed)
+	writeEntries(t, w, 1)
+	aborted := beginTxn(t, w)
+	writeTxn(t, aborted)
+	writeTxn(t, committed)
+	if err := aborted.Abort(); err != nil {
+		t.Fatal(err)
+	}
+	if lsn, err := committed.Commit(); err != nil || lsn != 9 {
+		t.Fatalf("Commit() = %d, %v, want 9", lsn, err)
+	}
+	open := beginTxn(t, w)
+	writeTxn(t, open)
+	writeEntries(t, w, 1)
+
+	if committed.ID() != 2 {
+		t.Fatalf("ID() = %d, want 2", committed.ID())
+	}
+	if got, want := committedLSNs(t, w, 0), []uint64{1, 4, 3, 7, 12}; !slices.Equal(got, want) {
+		t.Fatalf("CommittedEntries(0) = %v, want %v", got, want)
+	}
+	// Transactions that began before the start keep their later entries
+	if got, want := committedLSNs(t, w, 3), []uint64{4, 3, 7, 12}; !slices.Equal(got, want) {
+		t.Fatalf("CommittedEntries(3) = %v, want %v", got, want)
+	}
+
+	// The open transaction is dropped after a restart
+	w.Close()
+	w = openTestWAL(t, dir, testOptions())
+	entries, err := w.ReadCommitted()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 5 {
+		t.Fatalf("ReadCommitted() returned %d entries, want 5", len(entries))
+	}
+}
+
+func TestTxnDone(t *testing.T) {
+	w := openTestWAL(t, t.TempDir(), testOptions())
+
+	committed := beginTxn(t, w)
+	if _, err := committed.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	aborted := beginTxn(t, w)
+	if err := aborted.Abort(); err != nil {
+		t.Fatal(err)
+	}
+	last := w.LastLSN()
+
+	for _, txn := range []*Txn{committed, aborted} {
+		if _, err := txn.Write([]byte("late")); !errors.Is(err, ErrTxnDone) {
+			t.Fatalf("Write() error = %v, want ErrTxnDone", err)
+		}
+		if _, err := txn.Commit(); !errors.Is(err, ErrTxnDone) {
+			t.Fatalf("Commit() error = %v, want ErrTxnDone", err)
+		}
+		if err := txn.Abort(); !errors.Is(err, ErrTxnDone) {
+			t.Fatalf("Abort() error = %v, want ErrTxnDone", err)
+		}
+	}
+	if got := w.LastLSN(); got != last {
+		t.Fatalf("LastLSN() = %d, want %d", got, last)
+	}
+}
@@ -21,13 +21,84 @@ const (
 	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
 )
 
+// Kind of a WAL entry.
+type EntryKind int32
+
+const (
+	// Regular data entry.
+	EntryKind_ENTRY_KIND_DATA EntryKind = 0
+	// Begins a transaction.
+	EntryKind_ENTRY_KIND_TXN_BEGIN EntryKind = 1
+	// Commits a transaction.
+	EntryKind_ENTRY_KIND_TXN_COMMIT EntryKind = 2
+	// Aborts a transaction.
+	EntryKind_ENTRY_KIND_TXN_ABORT EntryKind = 3
+)
+
+// Enum value maps for EntryKind.
+var (
+	EntryKind_name = map[int32]string{
+		0: "ENTRY_KIND_DATA",
+		1: "ENTRY_KIND_TXN_BEGIN",
+		2: "ENTRY_KIND_TXN_COMMIT",
+		3: "ENTRY_KIND_TXN_ABORT",
+	}
+	EntryKind_value = map[string]int32{
+		"ENTRY_KIND_DATA":       0,
+		"ENTRY_KIND_TXN_BEGIN":  1,
+		"ENTRY_KIND_TXN_COMMIT": 2,
+		"ENTRY_KIND_TXN_ABORT":  3,
+	}
+)
+
+func (x EntryKind) Enum() *EntryKind {
+	p := new(EntryKind)
+	*p = x
+	return p
+}
+
+func (x EntryKind) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (EntryKind) Descriptor() protoreflect.EnumDescriptor {
+	return file_types_proto_enumTypes[0].Descriptor()
+}
+
+func (EntryKind) Type() protoreflect.EnumType {
+	return &file_types_proto_enumTypes[0]
+}
+
+func (x EntryKind) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use EntryKind.Descriptor instead.
+func (EntryKind) EnumDescriptor() ([]byte, []int) {
+	return file_types_proto_rawDescGZIP(), []int{0}
+}
+
 type WAL_Entry struct {
 	state             protoimpl.MessageState `protogen:"open.v1"`
 	LogSequenceNumber uint64                 `protobuf:"varint,1,opt,name=logSequenceNumber,proto3" json:"logSequenceNumber,omitempty"`
 	Data              []byte                 `protobuf:"bytes,2,opt,name=data,proto3" json:"data,omitempty"`
 	CRC               uint32                 `protobuf:"varint,3,opt,name=CRC,proto3" json:"CRC,omitempty"`
 	// Optional field for checkpointing.
-	IsCheckpoint  *bool `protobuf:"varint,4,opt,name=isCheckpoint,proto3,oneof" json:"isCheckpoint,omitempty"`
+	IsCheckpoint *bool `protobuf:"varint,4,opt,name=isCheckpoint,proto3,oneof" json:"isCheckpoint,omitempty"`
+	// Optional transaction the entry belongs to.
+	TxnID *uint64 `protobuf:"varint,5,opt,name=txnID,proto3,oneof" json:"txnID,omitempty"`
+	// Optional kind of the entry, ENTRY_KIND_DATA when unset.
+	Kind *EntryKind `protobuf:"varint,6,opt,name=kind,proto3,enum=EntryKind,oneof" json:"kind,omitempty"`
+	// Optional write time in Unix nanoseconds.
+	Timestamp *int64 `protobuf:"varint,7,opt,name=timestamp,proto3,oneof" json:"timestamp,omitempty"`
+	// Optional application defined record type.
+	RecordType *uint32 `protobuf:"varint,8,opt,name=recordType,proto3,oneof" json:"recordType,omitempty"`
+	// Optional key used to route or partition the entry.
+	RoutingKey []byte `protobuf:"bytes,9,opt,name=routingKey,proto3,oneof" json:"routingKey,omitempty"`
+	// Optional application defined headers.
+	Headers map[string]string `protobuf:"bytes,10,rep,name=headers,proto3" json:"headers,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	// Optional term of the writer, entries of older terms are rejected.
+	Term          *uint64 `protobuf:"varint,11,opt,name=term,proto3,oneof" json:"term,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -90,17 +161,94 @@ func (x *WAL_Entry) GetIsCheckpoint() bool {
 	return false
 }
 
+func (x *WAL_Entry) GetTxnID() uint64 {
+	if x != nil && x.TxnID != nil {
+		return *x.TxnID
+	}
+	return 0
+}
+
+func (x *WAL_Entry) GetKind() EntryKind {
+	if x != nil && x.Kind != nil {
+		return *x.Kind
+	}
+	return EntryKind_ENTRY_KIND_DATA
+}
+
+func (x *WAL_Entry) GetTimestamp() int64 {
+	if x != nil && x.Timestamp != nil {
+		return *x.Timestamp
+	}
+	return 0
+}
+
+func (x *WAL_Entry) GetRecordType() uint32 {
+	if x != nil && x.RecordType != nil {
+		return *x.RecordType
+	}
+	return 0
+}
+
+func (x *WAL_Entry) GetRoutingKey() []byte {
+	if x != nil {
+		return x.RoutingKey
+	}
+	return nil
+}
+
+func (x *WAL_Entry) GetHeaders() map[string]string {
+	if x != nil {
+		return x.Headers
+	}
+	return nil
+}
+
+func (x *WAL_Entry) GetTerm() uint64 {
+	if x != nil && x.Term != nil {
+		return *x.Term
+	}
+	return 0
+}
+
 var File_types_proto protoreflect.FileDescriptor
 
 const file_types_proto_rawDesc = "" +
 	"\n" +
-	"\vtypes.proto\"\x99\x01\n" +
+	"\vtypes.proto\"\x96\x04\n" +
 	"\tWAL_Entry\x12,\n" +
 	"\x11logSequenceNumber\x18\x01 \x01(\x04R\x11logSequenceNumber\x12\x12\n" +
 	"\x04data\x18\x02 \x01(\fR\x04data\x12\x10\n" +
 	"\x03CRC\x18\x03 \x01(\rR\x03CRC\x12'\n" +
-	"\fisCheckpoint\x18\x04 \x01(\bH\x00R\fisCheckpoint\x88\x01\x01B\x0f\n" +
-	"\r_isCheckpointB\x1cZ\x1agithub.com/wizenheimer/walb\x06proto3"
+	"\fisCheckpoint\x18\x04 \x01(\bH\x00R\fisCheckpoint\x88\x01\x01\x12\x19\n" +
+	"\x05txnID\x18\x05 \x01(\x04H\x01R\x05txnID\x88\x01\x01\x12#\n" +
+	"\x04kind\x18\x06 \x01(\x0e2\n" +
+	".EntryKindH\x02R\x04kind\x88\x01\x01\x12!\n" +
+	"\ttimestamp\x18\a \x01(\x03H\x03R\ttimestamp\x88\x01\x01\x12#\n" +
+	"\n" +
+	"recordType\x18\b \x01(\rH\x04R\n" +
+	"recordType\x88\x01\x01\x12#\n" +
+	"\n" +
+	"routingKey\x18\t \x01(\fH\x05R\n" +
+	"routingKey\x88\x01\x01\x121\n" +
+	"\aheaders\x18\n" +
+	" \x03(\v2\x17.WAL_Entry.HeadersEntryR\aheaders\x12\x17\n" +
+	"\x04term\x18\v \x01(\x04H\x06R\x04term\x88\x01\x01\x1a:\n" +
+	"\fHeadersEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01B\x0f\n" +
+	"\r_isCheckpointB\b\n" +
+	"\x06_txnIDB\a\n" +
+	"\x05_kindB\f\n" +
+	"\n" +
+	"_timestampB\r\n" +
+	"\v_recordTypeB\r\n" +
+	"\v_routingKeyB\a\n" +
+	"\x05_term*o\n" +
+	"\tEntryKind\x12\x13\n" +
+	"\x0fENTRY_KIND_DATA\x10\x00\x12\x18\n" +
+	"\x14ENTRY_KIND_TXN_BEGIN\x10\x01\x12\x19\n" +
+	"\x15ENTRY_KIND_TXN_COMMIT\x10\x02\x12\x18\n" +
+	"\x14ENTRY_KIND_TXN_ABORT\x10\x03B\x1cZ\x1agithub.com/wizenheimer/walb\x06proto3"
 
 var (
 	file_types_proto_rawDescOnce sync.Once
@@ -114,16 +262,21 @@ func file_types_proto_rawDescGZIP() []byte {
 	return file_types_proto_rawDescData
 }
 
-var file_types_proto_msgTypes = make([]protoimpl.MessageInfo, 1)
+var file_types_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_types_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
 var file_types_proto_goTypes = []any{
-	(*WAL_Entry)(nil), // 0: WAL_Entry
+	(EntryKind)(0),    // 0: EntryKind
+	(*WAL_Entry)(nil), // 1: WAL_Entry
+	nil,               // 2: WAL_Entry.HeadersEntry
 }
 var file_types_proto_depIdxs = []int32{
-	0, // [0:0] is the sub-list for method output_type
-	0, // [0:0] is the sub-list for method input_type
-	0, // [0:0] is the sub-list for extension type_name
-	0, // [0:0] is the sub-list for extension extendee
-	0, // [0:0] is the sub-list for field type_name
+	0, // 0: WAL_Entry.kind:type_name -> EntryKind
+	2, // 1: WAL_Entry.headers:type_name -> WAL_Entry.HeadersEntry
+	2, // [2:2] is the sub-list for method output_type
+	2, // [2:2] is the sub-list for method input_type
+	2, // [2:2] is the sub-list for extension type_name
+	2, // [2:2] is the sub-list for extension extendee
+	0, // [0:2] is the sub-list for field type_name
 }
 
 func init() { file_types_proto_init() }
@@ -137,13 +290,14 @@ func file_types_proto_init() {
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_types_proto_rawDesc), len(file_types_proto_rawDesc)),
-			NumEnums:      0,
-			NumMessages:   1,
+			NumEnums:      1,
+			NumMessages:   2,
 			NumExtensions: 0,
 			NumServices:   0,
 		},
 		GoTypes:           file_types_proto_goTypes,
 		DependencyIndexes: file_types_proto_depIdxs,
+		EnumInfos:         file_types_proto_enumTypes,
 		MessageInfos:      file_types_proto_msgTypes,
 	}.Build()
 	File_types_proto = out.File
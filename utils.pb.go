@@ -2,6 +2,16 @@ package wal
 
 import "google.golang.org/protobuf/proto"
 
+// Marshal marshals a WAL_Entry to a byte slice
+func Marshal(entry *WAL_Entry) ([]byte, error) {
+	return proto.Marshal(entry)
+}
+
+// Unmarshal unmarshals a byte slice to a WAL_Entry
+func Unmarshal(data []byte, entry *WAL_Entry) error {
+	return proto.Unmarshal(data, entry)
+}
+
 // MustMarshal marshals a WAL_Entry to a byte slice
 // It panics if the marshalling fails
 func MustMarshal(entry *WAL_Entry) []byte {
@@ -3,68 +3,197 @@ package wal
 import (
 	"encoding/binary"
 	"fmt"
-	"hash/crc32"
 	"io"
+	"maps"
+	"slices"
+	"time"
 )
 
-// calculateCRC calculates the CRC32 checksum of the data and log sequence number.
+// entryCRC calculates the checksum of an entry.
 //
-// The CRC is computed over both the entry data and LSN to detect corruption.
-func calculateCRC(data []byte, lsn uint64) uint32 {
-	h := crc32.NewIEEE()
-	h.Write(data)
-	binary.Write(h, binary.LittleEndian, lsn)
+// The CRC is computed over the entry data and LSN to detect corruption, using
+// the checksum algorithm of the segment the entry belongs to. The optional
+// fields that are set follow, each preceded by its field number, so entries
+// without optional fields keep the CRC computed by earlier versions.
+func entryCRC(algo ChecksumAlgorithm, entry *WAL_Entry) uint32 {
+	h := algo.newHash()
+	h.Write(entry.Data)
+	binary.Write(h, binary.LittleEndian, entry.LogSequenceNumber)
+
+	if entry.TxnID != nil {
+		h.Write([]byte{5})
+		binary.Write(h, binary.LittleEndian, *entry.TxnID)
+	}
+	if entry.Kind != nil {
+		h.Write([]byte{6})
+		binary.Write(h, binary.LittleEndian, int32(*entry.Kind))
+	}
+	if entry.Timestamp != nil {
+		h.Write([]byte{7})
+		binary.Write(h, binary.LittleEndian, *entry.Timestamp)
+	}
+	if entry.RecordType != nil {
+		h.Write([]byte{8})
+		binary.Write(h, binary.LittleEndian, *entry.RecordType)
+	}
+	if entry.RoutingKey != nil {
+		h.Write([]byte{9})
+		writeCRCBytes(h, entry.RoutingKey)
+	}
+	if len(entry.Headers) > 0 {
+		// Map order is random, hash the headers sorted by key
+		h.Write([]byte{10})
+		binary.Write(h, binary.LittleEndian, uint32(len(entry.Headers)))
+		keys := slices.Sorted(maps.Keys(entry.Headers))
+		for _, key := range keys {
+			writeCRCBytes(h, []byte(key))
+			writeCRCBytes(h, []byte(entry.Headers[key]))
+		}
+	}
+	if entry.Term != nil {
+		h.Write([]byte{11})
+		binary.Write(h, binary.LittleEndian, *entry.Term)
+	}
 	return h.Sum32()
 }
 
+// writeCRCBytes hashes a variable length field prefixed with its length
+// so that adjacent fields cannot be confused
+func writeCRCBytes(h io.Writer, data []byte) {
+	binary.Write(h, binary.LittleEndian, uint32(len(data)))
+	h.Write(data)
+}
+
+// EntryMeta is the optional metadata of an entry.
+type EntryMeta struct {
+	// Timestamp is when the entry was written
+	// WriteEntryWithMeta uses the current time when it is zero
+	Timestamp time.Time
+	// RecordType is an application defined record type
+	// zero means no record type
+	RecordType uint32
+	// RoutingKey is the key used to route or partition the entry
+	RoutingKey []byte
+	// Headers are application defined key/value headers
+	Headers map[string]string
+}
+
+// Meta returns the metadata of the entry.
+//
+// Fields that are not set are left at their zero value.
+func (x *WAL_Entry) Meta() EntryMeta {
+	var meta EntryMeta
+	if x.Timestamp != nil {
+		meta.Timestamp = time.Unix(0, *x.Timestamp)
+	}
+	meta.RecordType = x.GetRecordType()
+	meta.RoutingKey = x.GetRoutingKey()
+	meta.Headers = x.GetHeaders()
+	return meta
+}
+
+// setMeta sets the metadata fields of the entry
+// zero values leave the corresponding field unset
+func (x *WAL_Entry) setMeta(meta EntryMeta) {
+	if !meta.Timestamp.IsZero() {
+		timestamp := meta.Timestamp.UnixNano()
+		x.Timestamp = &timestamp
+	}
+	if meta.RecordType != 0 {
+		recordType := meta.RecordType
+		x.RecordType = &recordType
+	}
+	x.RoutingKey = meta.RoutingKey
+	if len(meta.Headers) > 0 {
+		x.Headers = meta.Headers
+	}
+}
+
 // NewEntry creates a new WAL entry with the given LSN and data.
 //
-// The CRC checksum is automatically calculated and set for the entry.
+// The CRC checksum is automatically calculated with ChecksumIEEE and set for the entry.
 func NewEntry(lsn uint64, data []byte) *WAL_Entry {
+	return NewEntryWithChecksum(lsn, data, ChecksumIEEE)
+}
+
+// NewEntryWithChecksum creates a new WAL entry with the given LSN and data.
+//
+// The CRC checksum is calculated with the given algorithm, which must match the
+// checksum algorithm of the segment the entry is written to.
+func NewEntryWithChecksum(lsn uint64, data []byte, algo ChecksumAlgorithm) *WAL_Entry {
 	entry := &WAL_Entry{
 		LogSequenceNumber: lsn,
 		Data:              data,
 	}
-	entry.CRC = calculateCRC(data, lsn)
+	entry.CRC = entryCRC(algo, entry)
 	return entry
 }
 
 // NewCheckpointEntry creates a new checkpoint WAL entry with the given LSN and data.
 //
-// The CRC checksum is automatically calculated and the entry is marked as a checkpoint.
+// The CRC checksum is automatically calculated with ChecksumIEEE and the entry is
+// marked as a checkpoint.
 func NewCheckpointEntry(lsn uint64, data []byte) *WAL_Entry {
-	entry := &WAL_Entry{
-		LogSequenceNumber: lsn,
-		Data:              data,
-	}
+	entry := NewEntry(lsn, data)
 	checkpoint := true
 	entry.IsCheckpoint = &checkpoint
-	entry.CRC = calculateCRC(data, lsn)
 	return entry
 }
 
-// VerifyEntry verifies the CRC32 checksum of an entry.
+// EntryCRC calculates the checksum of an entry with the given algorithm.
+//
+// The checksum covers the data, the LSN and the transaction, metadata and term
+// fields when set, so it must be calculated after every field is set. Entries
+// built for AppendEntries use it to get a valid CRC:
 //
-// Returns an error if the computed CRC doesn't match the entry's stored CRC,
-// indicating potential data corruption.
+//	entry := &wal.WAL_Entry{LogSequenceNumber: lsn, Data: data, Term: &term}
+//	entry.CRC = wal.EntryCRC(wal.ChecksumCRC32C, entry)
+func EntryCRC(algo ChecksumAlgorithm, entry *WAL_Entry) uint32 {
+	return entryCRC(algo, entry)
+}
+
+// VerifyEntry verifies the CRC32 (IEEE) checksum of an entry.
+//
+// Returns an error wrapping ErrCRCMismatch if the computed CRC doesn't match
+// the entry's stored CRC, indicating potential data corruption. Entries from
+// segments using another checksum algorithm must be verified with VerifyEntryWith.
 func VerifyEntry(entry *WAL_Entry) error {
-	expectedCRC := calculateCRC(entry.Data, entry.LogSequenceNumber)
+	return VerifyEntryWith(entry, ChecksumIEEE)
+}
+
+// VerifyEntryWith verifies the checksum of an entry using the given algorithm.
+//
+// The checksum covers the data, the LSN and the transaction and metadata fields
+// when set.
+//
+// Returns an error wrapping ErrCRCMismatch if the computed CRC doesn't match
+// the entry's stored CRC, indicating potential data corruption.
+func VerifyEntryWith(entry *WAL_Entry, algo ChecksumAlgorithm) error {
+	expectedCRC := entryCRC(algo, entry)
 	if entry.CRC != expectedCRC {
-		return fmt.Errorf("CRC mismatch: expected %d, got %d", expectedCRC, entry.CRC)
+		return fmt.Errorf("%w: expected %d, got %d", ErrCRCMismatch, expectedCRC, entry.CRC)
 	}
 	return nil
 }
 
 // ReadAllEntries reads all entries from the reader and verifies their CRC checksums.
 //
+// Checksums are verified with the algorithm recorded in the segment header, or
+// ChecksumIEEE for header-less segments.
+//
 // Reading stops at io.EOF. Returns an error if reading fails or if any entry
-// has a CRC mismatch.
+// has a CRC mismatch; corruption is reported as a *CorruptionError.
 func ReadAllEntries(r io.Reader) ([]*WAL_Entry, error) {
-	reader := NewBinaryEntryReader(r)
+	return readAllEntries(NewBinaryEntryReader(r))
+}
+
+// readAllEntries reads all entries from the entry reader
+// and verifies their CRC checksums
+func readAllEntries(reader *BinaryEntryReader) ([]*WAL_Entry, error) {
 	var entries []*WAL_Entry
 
 	for {
-		entry, err := reader.ReadEntry()
+		entry, err := reader.ReadVerifiedEntry()
 		if err == io.EOF {
 			break
 		}
@@ -72,11 +201,6 @@ func ReadAllEntries(r io.Reader) ([]*WAL_Entry, error) {
 			return entries, err
 		}
 
-		// Verify CRC at application level, not transport level
-		if err := VerifyEntry(entry); err != nil {
-			return entries, err
-		}
-
 		entries = append(entries, entry)
 	}
 
@@ -90,12 +214,17 @@ func ReadAllEntries(r io.Reader) ([]*WAL_Entry, error) {
 //
 // Returns the entries, the LSN of the last checkpoint (0 if none), and any error.
 func ReadEntriesWithCheckpoint(r io.Reader) ([]*WAL_Entry, uint64, error) {
-	reader := NewBinaryEntryReader(r)
+	return readEntriesWithCheckpoint(NewBinaryEntryReader(r))
+}
+
+// readEntriesWithCheckpoint reads entries from the entry reader
+// keeping only entries from the last checkpoint onwards
+func readEntriesWithCheckpoint(reader *BinaryEntryReader) ([]*WAL_Entry, uint64, error) {
 	var entries []*WAL_Entry
 	var checkpointLSN uint64
 
 	for {
-		entry, err := reader.ReadEntry()
+		entry, err := reader.ReadVerifiedEntry()
 		if err == io.EOF {
 			break
 		}
@@ -103,11 +232,6 @@ func ReadEntriesWithCheckpoint(r io.Reader) ([]*WAL_Entry, uint64, error) {
 			return entries, checkpointLSN, err
 		}
 
-		// Verify CRC at application level, not transport level
-		if err := VerifyEntry(entry); err != nil {
-			return entries, checkpointLSN, err
-		}
-
 		if entry.IsCheckpoint != nil && *entry.IsCheckpoint {
 			// Reset entries from checkpoint
 			entries = []*WAL_Entry{entry}
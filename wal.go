@@ -40,6 +40,8 @@ package wal
 
 import (
 	"context"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -49,29 +51,139 @@ import (
 
 var defaultSyncInterval = 3 * time.Second
 
+// Durability controls when a write is acknowledged to the caller
+type Durability int
+
+const (
+	// DurabilityNone acknowledges a write once it is buffered in memory.
+	// The entry becomes durable with the next background sync.
+	DurabilityNone Durability = iota
+	// DurabilityFlush acknowledges a write once it is handed to the
+	// operating system. The entry survives a process crash but not a
+	// machine crash until the next sync.
+	DurabilityFlush
+	// DurabilityFsync acknowledges a write once it is synced to disk.
+	// Concurrent writers share a single sync (group commit).
+	DurabilityFsync
+)
+
+// valid reports whether the durability is known
+func (d Durability) valid() bool {
+	return d >= DurabilityNone && d <= DurabilityFsync
+}
+
+// WriteOptions are the per-write options for the WAL
+type WriteOptions struct {
+	// Durability is the durability required before
+	// the write is acknowledged
+	Durability Durability
+}
+
 // WALOptions are the options for the WAL
 type WALOptions struct {
 	// MaxSegmentSize is the maximum size of a segment
 	// in bytes
 	MaxSegmentSize int64
 	// MaxSegments is the maximum number of segments
-	// to keep in memory
+	// to keep, it is only used when Retention is nil
 	MaxSegments int
+	// Retention is the policy deciding which segments
+	// to delete, segments needed to recover from the
+	// latest checkpoint are kept regardless
+	Retention RetentionPolicy
+	// RetentionInterval is the interval at which the
+	// retention policy is evaluated, on top of after
+	// every rotation
+	RetentionInterval time.Duration
+	// Archiver copies sealed segments to long-term
+	// storage before they are deleted, nil disables
+	// archiving
+	Archiver Archiver
+	// OnRetentionBlocked is called with an error wrapping
+	// ErrRetentionBlocked when the retention policy cannot
+	// be honored,
+	// a warning is logged when it is nil, it is called
+	// while retention runs and must not call ApplyRetention,
+	// TruncateFront or TruncateBack
+	OnRetentionBlocked func(err error)
 	// SyncInterval is the interval at which to sync the WAL
 	// to disk
 	SyncInterval time.Duration
+	// Checksum is the checksum algorithm for new segments
+	// existing segments keep the algorithm they were written with
+	Checksum ChecksumAlgorithm
+	// MaxRecordSize is the largest encoded entry
+	// in bytes, larger writes are rejected and larger
+	// length prefixes are reported as corruption
+	MaxRecordSize int
+	// Compression is the codec used to compress entries
+	// it is disabled by default, and compressed and
+	// uncompressed entries can be read side by side
+	Compression Compression
+	// KeyProvider supplies the keys to encrypt segments with
+	// new segments are encrypted with its current key, nil
+	// disables encryption
+	KeyProvider KeyProvider
 	// EnableFsync is whether to enable fsync
-	// for the WAL
+	// for the WAL, it is only used when SyncPolicy
+	// is SyncPolicyDefault
 	EnableFsync bool
+	// SyncPolicy is how synced data is persisted
+	// it applies to Sync, rotation, Close and checkpoints
+	SyncPolicy SyncPolicy
+	// Gaps is how AppendAt and AppendEntries handle
+	// an LSN past the next one, gaps are rejected
+	// by default
+	Gaps GapPolicy
+}
+
+// maxRecordSize returns the effective maximum record size
+func (o WALOptions) maxRecordSize() int {
+	if o.MaxRecordSize > 0 {
+		return o.MaxRecordSize
+	}
+	return defaultMaxRecordSize
+}
+
+// retention returns the effective retention policy
+// enforcing MaxSegments when no policy is set
+func (o WALOptions) retention() RetentionPolicy {
+	if o.Retention != nil {
+		return o.Retention
+	}
+	return MaxSegmentsRetention{MaxSegments: o.MaxSegments}
+}
+
+// retentionInterval returns the effective retention interval
+func (o WALOptions) retentionInterval() time.Duration {
+	if o.RetentionInterval > 0 {
+		return o.RetentionInterval
+	}
+	return defaultRetentionInterval
+}
+
+// syncPolicy returns the effective sync policy
+// honoring EnableFsync when no explicit policy is set
+func (o WALOptions) syncPolicy() SyncPolicy {
+	if o.SyncPolicy != SyncPolicyDefault {
+		return o.SyncPolicy
+	}
+	if o.EnableFsync {
+		return SyncPolicyFsync
+	}
+	return SyncPolicyNone
 }
 
 // DefaultWALOptions returns the default WAL options
 func DefaultWALOptions() WALOptions {
 	return WALOptions{
-		MaxSegmentSize: 4 * 1024 * 1024, // 4MB
-		MaxSegments:    10,
-		SyncInterval:   defaultSyncInterval,
-		EnableFsync:    true,
+		MaxSegmentSize:    4 * 1024 * 1024, // 4MB
+		MaxSegments:       10,
+		RetentionInterval: defaultRetentionInterval,
+		SyncInterval:      defaultSyncInterval,
+		Checksum:          ChecksumCRC32C,
+		MaxRecordSize:     defaultMaxRecordSize,
+		EnableFsync:       true,
 	}
 }
 
@@ -89,6 +201,9 @@ type WAL struct {
 	// segmentMgr is the segment manager for the WAL
 	// it is used to create and manage the segment files
 	segmentMgr SegmentManager
+	// source is the segment source for the WAL
+	// it is used to open segments for reading
+	source *segmentSource
 	// options are the options for the WAL
 	// it is used to configure the WAL
 	options WALOptions
@@ -108,6 +223,62 @@ type WAL struct {
 	// lastLSN is the last LSN for the WAL
 	// it is used to write the entries to the current segment
 	lastLSN uint64
+	// term is the current term for the WAL
+	// it is used to reject writes of older terms
+	term uint64
+	// firstLSN is the lowest LSN visible to readers
+	// it is set by TruncateFront, 0 if the front was never truncated
+	firstLSN uint64
+	// index is the LSN index for the WAL
+	// it is used to find entries without scanning segments
+	index *lsnIndex
+
+	// syncedLSN is the highest LSN known to be synced to disk
+	// it is used to release callers waiting on a group commit
+	syncedLSN uint64
+	// syncRound is the group commit currently syncing, if any
+	// it is used to elect a single leader per sync
+	syncRound *syncRound
+	// durable is closed and replaced whenever syncedLSN advances
+	// it is used to wake up callers of WaitForDurable
+	durable chan struct{}
+	// failure is the error that left the WAL unusable until it
+	// is reopened, such as a failed sync: the kernel may have
+	// dropped the unsynced pages, and a later sync succeeding
+	// does not make them durable
+	failure error
+	// flushedLSN is the highest LSN flushed to its segment
+	// it is used to wake up subscriptions
+	flushedLSN uint64
+	// flushed is closed and replaced whenever flushedLSN advances
+	// it is used to wake up subscriptions
+	flushed chan struct{}
+	// subscriptions are the running subscriptions
+	// they are rewound by TruncateBack
+	subscriptions map[*Subscription]struct{}
+	// closed is closed once the WAL has been closed
+	// it is used to release callers of WaitForDurable
+	closed chan struct{}
+
+	// retentionMu serializes runs of the retention policy
+	retentionMu sync.Mutex
+	// archivedThrough is the last segment archived
+	// segments are archived in order, it is guarded
+	// by retentionMu
+	archivedThrough int
+	// retentionDue wakes up the retention loop
+	// it is signaled after every rotation
+	retentionDue chan struct{}
+	// checkpoint is where the latest checkpoint is stored
+	// it is nil when the WAL holds no checkpoint
+	checkpoint *checkpointLocation
+	// checkpointScanned is whether checkpoint is known
+	// it is found lazily by scanning the segments
+	checkpointScanned bool
+
+	// tailRecovery is the torn tail discarded by Open
+	// it is nil if the last segment ended cleanly
+	tailRecovery *TailRecovery
 
 	// syncTimer is the timer for the WAL
 	// it is used to sync the WAL to disk
@@ -129,11 +300,34 @@ type WAL struct {
 // If existing segments are found, Open resumes from the last segment and loads
 // the last LSN. If no segments exist, it creates a new one starting at segment 0.
 //
+// If the last segment ends in a partial or CRC-invalid record, typically left
+// behind by a crash mid-write, Open truncates it back to the last valid entry
+// and reports what was discarded through TailRecovery. A damaged record followed
+// by a complete record with a valid checksum is not a torn tail, and Open fails
+// with a *CorruptionError instead.
+//
 // Open also starts a background goroutine that periodically syncs the WAL to disk
 // based on the configured SyncInterval.
 //
 // The returned WAL must be closed with Close() to ensure all data is flushed.
-func Open(segmentMgr SegmentManager, opts WALOptions) (*WAL, error) {
+// When Open fails, segment managers implementing io.Closer are closed, which
+// releases the directory lock of a FileSegmentManager.
+func Open(segmentMgr SegmentManager, opts WALOptions) (_ *WAL, err error) {
+	defer func() {
+		if err != nil {
+			closeSegmentManager(segmentMgr)
+		}
+	}()
+
+	if !opts.Checksum.valid() {
+		return nil, fmt.Errorf("unsupported checksum algorithm %d", opts.Checksum)
+	}
+	if opts.Compression != CompressionNone {
+		if _, err := lookupCodec(opts.Compression); err != nil {
+			return nil, err
+		}
+	}
+
 	segments, err := segmentMgr.ListSegments()
 	if err != nil {
 		return nil, fmt.Errorf("list segments: %w", err)
@@ -154,60 +348,318 @@ func Open(segmentMgr SegmentManager, opts WALOptions) (*WAL, error) {
 
 	ctx, cancel := context.WithCancel(context.Background())
 
+	source := &segmentSource{
+		segmentMgr:    segmentMgr,
+		maxRecordSize: opts.maxRecordSize(),
+		keys:          opts.KeyProvider,
+	}
+
 	wal := &WAL{
 		segmentMgr:     segmentMgr,
+		source:         source,
 		options:        opts,
 		currentSegment: currentSegment,
 		currentWriter:  writer,
-		entryWriter:    NewBinaryEntryWriter(writer),
+		index:          newLSNIndex(source),
 		syncTimer:      time.NewTimer(opts.SyncInterval),
+		durable:        make(chan struct{}),
+		flushed:        make(chan struct{}),
+		subscriptions:  make(map[*Subscription]struct{}),
+		retentionDue:   make(chan struct{}, 1),
+		closed:         make(chan struct{}),
 		ctx:            ctx,
 		cancel:         cancel,
 	}
+	wal.entryWriter = wal.newEntryWriter(writer)
+
+	// Sealed segments are archived again after a restart
+	wal.archivedThrough = -1
 
 	// Read last LSN from current segment
-	if err := wal.loadLastLSN(); err != nil {
+	if err := wal.loadLastLSN(segments); err != nil {
 		writer.Close()
 		cancel()
 		return nil, fmt.Errorf("load last LSN: %w", err)
 	}
 
+	// Write the header of a new segment
+	if err := wal.initSegment(); err != nil {
+		writer.Close()
+		cancel()
+		return nil, fmt.Errorf("init segment: %w", err)
+	}
+
+	// Hide truncated entries and complete an interrupted truncation
+	if err := wal.recoverTruncation(); err != nil {
+		wal.currentWriter.Close()
+		cancel()
+		return nil, fmt.Errorf("recover truncation: %w", err)
+	}
+
+	// Start a new segment if the current one is not encrypted
+	// as the options require
+	if err := wal.rotateForEncryption(); err != nil {
+		wal.currentWriter.Close()
+		cancel()
+		return nil, fmt.Errorf("rotate: %w", err)
+	}
+
+	// Everything already on disk is durable
+	wal.syncedLSN = wal.lastLSN
+	wal.flushedLSN = wal.lastLSN
+
 	// Start background sync
-	wal.wg.Add(1)
+	wal.wg.Add(2)
 	go wal.syncLoop()
+	go wal.retentionLoop()
 
 	return wal, nil
 }
 
+// TailRecovery describes a torn tail discarded from the last segment by Open.
+//
+// A tail is torn when the process crashed in the middle of a write, leaving a
+// partial or CRC-invalid record that no valid record follows at the end of the
+// segment.
+type TailRecovery struct {
+	// SegmentID is the segment that was truncated
+	SegmentID int
+	// Offset is the size the segment was truncated to, the end
+	// of the last valid entry
+	Offset int64
+	// DiscardedBytes is the number of bytes discarded
+	DiscardedBytes int64
+	// LastLSN is the LSN of the last valid entry, 0 if none
+	LastLSN uint64
+	// Err is the error that marked the start of the torn tail
+	Err error
+}
+
+// SegmentTruncater is implemented by segment managers that can shrink a segment.
+//
+// Open uses it to discard a torn tail after a crash. Segment managers that do not
+// implement it cause Open to fail when a torn tail is found.
+type SegmentTruncater interface {
+	// TruncateSegment truncates the segment to the given size in bytes.
+	TruncateSegment(id int, size int64) error
+}
+
 // loadLastLSN loads the last LSN from the current segment
-func (w *WAL) loadLastLSN() error {
+// and truncates a torn tail left behind by a crash
+// an empty segment falls back to its header or the previous segments
+func (w *WAL) loadLastLSN(segments []int) error {
 	reader, err := w.segmentMgr.OpenSegment(w.currentSegment)
 	if err != nil {
 		return err
 	}
 	defer reader.Close()
 
-	entryReader := NewBinaryEntryReader(reader)
+	entryReader := w.source.newReader(reader, w.currentSegment)
 	var lastEntry *WAL_Entry
+	var tailErr error
 
 	for {
-		entry, err := entryReader.ReadEntry()
+		entry, err := entryReader.ReadVerifiedEntry()
 		if err == io.EOF {
 			break
 		}
+		if isCorruption(err) {
+			tailErr = err
+			break
+		}
 		if err != nil {
 			return fmt.Errorf("read entry: %w", err)
 		}
 		lastEntry = entry
 	}
 
-	if lastEntry != nil {
+	// Keep appending in the format the segment was created with
+	if err := w.entryWriter.setHeader(entryReader.header); err != nil {
+		return err
+	}
+
+	if err := w.loadTerm(lastEntry); err != nil {
+		return err
+	}
+
+	switch header := entryReader.header; {
+	case lastEntry != nil:
 		w.lastLSN = lastEntry.LogSequenceNumber
+	case header != nil && header.BaseLSN > 0:
+		w.lastLSN = header.BaseLSN - 1
+	default:
+		lastLSN, err := w.lastLSNBefore(segments)
+		if err != nil {
+			return err
+		}
+		w.lastLSN = lastLSN
+	}
+
+	if tailErr != nil {
+		return w.truncateTornTail(entryReader.header, entryReader.Offset(), tailErr)
 	}
 
 	return nil
 }
 
+// lastLSNBefore loads the last LSN from the segments preceding the
+// current one, used when the current segment holds no entries
+func (w *WAL) lastLSNBefore(segments []int) (uint64, error) {
+	for i := len(segments) - 1; i >= 0; i-- {
+		segID := segments[i]
+		if segID >= w.currentSegment {
+			continue
+		}
+
+		reader, err := w.segmentMgr.OpenSegment(segID)
+		if err != nil {
+			return 0, err
+		}
+		entryReader := w.source.newReader(reader, segID)
+		entries, err := readAllEntries(entryReader)
+		reader.Close()
+		if err != nil {
+			return 0, fmt.Errorf("read segment %d: %w", segID, err)
+		}
+
+		if len(entries) > 0 {
+			return entries[len(entries)-1].LogSequenceNumber, nil
+		}
+		if header := entryReader.header; header != nil && header.BaseLSN > 0 {
+			return header.BaseLSN - 1, nil
+		}
+	}
+	return 0, nil
+}
+
+// truncateTornTail truncates the current segment back to the
+// end of the last valid entry and records what was discarded
+// only a partial record or a record failing its checksum that
+// no valid record follows is torn, other corruption fails Open
+func (w *WAL) truncateTornTail(header *SegmentHeader, offset int64, tailErr error) error {
+	size, err := w.segmentMgr.CurrentSegmentSize(w.currentSegment)
+	if err != nil {
+		return err
+	}
+
+	// Only the last record can be torn, a damaged record
+	// followed by valid ones is corruption and is kept
+	if !errors.Is(tailErr, ErrTruncatedEntry) && !errors.Is(tailErr, ErrCRCMismatch) {
+		return tailErr
+	}
+	follows, err := w.validRecordAfter(header, offset)
+	if err != nil {
+		return err
+	}
+	if follows {
+		return tailErr
+	}
+
+	truncater, ok := w.segmentMgr.(SegmentTruncater)
+	if !ok {
+		return fmt.Errorf("torn tail in segment %d at offset %d: %w", w.currentSegment, offset, tailErr)
+	}
+
+	if err := truncater.TruncateSegment(w.currentSegment, offset); err != nil {
+		return fmt.Errorf("truncate torn tail: %w", err)
+	}
+
+	w.tailRecovery = &TailRecovery{
+		SegmentID:      w.currentSegment,
+		Offset:         offset,
+		DiscardedBytes: size - offset,
+		LastLSN:        w.lastLSN,
+		Err:            tailErr,
+	}
+	log.Printf("Warning: discarded %d bytes of torn tail: %v", size-offset, tailErr)
+
+	return nil
+}
+
+// validRecordAfter reports whether a complete record with a valid
+// checksum starts anywhere after offset in the current segment
+func (w *WAL) validRecordAfter(header *SegmentHeader, offset int64) (bool, error) {
+	reader, err := w.segmentMgr.OpenSegment(w.currentSegment)
+	if err != nil {
+		return false, err
+	}
+	defer reader.Close()
+
+	if _, err := io.CopyN(io.Discard, reader, offset+1); err != nil {
+		if err == io.EOF {
+			return false, nil
+		}
+		return false, fmt.Errorf("read segment %d: %w", w.currentSegment, err)
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return false, fmt.Errorf("read segment %d: %w", w.currentSegment, err)
+	}
+
+	algo := segmentChecksum(header)
+	for i := range data {
+		if usesFrames(header) && validFrameAt(data[i:], algo) {
+			return true, nil
+		}
+		if !usesFrames(header) && validLengthPrefixedAt(data[i:], algo, w.options.maxRecordSize()) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// validFrameAt reports whether data starts with a complete frame
+// whose header and frame checksums match
+func validFrameAt(data []byte, algo ChecksumAlgorithm) bool {
+	if len(data) < frameHeaderSize {
+		return false
+	}
+	fh := decodeFrameHeader(data)
+	if fh.headerChecksum != frameHeaderChecksum(algo, fh.length, fh.flags) {
+		return false
+	}
+	if uint64(fh.length) > uint64(len(data)-frameHeaderSize) {
+		return false
+	}
+	payload := data[frameHeaderSize : frameHeaderSize+int(fh.length)]
+	return fh.checksum == frameChecksum(algo, fh.length, fh.flags, payload)
+}
+
+// validLengthPrefixedAt reports whether data starts with a complete
+// length-prefixed entry whose CRC matches
+func validLengthPrefixedAt(data []byte, algo ChecksumAlgorithm, maxRecordSize int) bool {
+	if len(data) < 4 {
+		return false
+	}
+	size := binary.LittleEndian.Uint32(data)
+	if size == 0 || int64(size) > int64(maxRecordSize) || uint64(size) > uint64(len(data)-4) {
+		return false
+	}
+
+	var entry WAL_Entry
+	if err := Unmarshal(data[4:4+size], &entry); err != nil {
+		return false
+	}
+	return VerifyEntryWith(&entry, algo) == nil
+}
+
+// LastLSN returns the LSN of the last entry of the WAL, the next entry written
+// gets LastLSN()+1. It is 0 for a new WAL.
+//
+// This method is thread-safe.
+func (w *WAL) LastLSN() uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.lastLSN
+}
+
+// TailRecovery returns the torn tail discarded by Open, or nil if the
+// last segment ended cleanly.
+func (w *WAL) TailRecovery() *TailRecovery {
+	return w.tailRecovery
+}
+
 // WriteEntry writes a new entry to the WAL and returns its Log Sequence Number (LSN).
 //
 // The LSN is a monotonically increasing identifier that uniquely identifies this entry.
@@ -219,6 +671,111 @@ func (w *WAL) WriteEntry(data []byte) (uint64, error) {
 	return w.writeEntry(data, false)
 }
 
+// WriteEntrySync writes a new entry to the WAL and waits until it is synced to disk.
+//
+// Concurrent callers are grouped into a single commit: the first caller to find
+// no sync in flight becomes the leader, flushes the buffer and syncs the segment
+// on behalf of every entry written so far, while the others wait for it to finish.
+// Writers that arrive during a sync keep appending to the buffer and are picked
+// up by the next leader.
+//
+// This method is thread-safe and can be called concurrently from multiple goroutines.
+func (w *WAL) WriteEntrySync(data []byte) (uint64, error) {
+	return w.WriteEntryWithOptions(data, WriteOptions{Durability: DurabilityFsync})
+}
+
+// WriteEntryWithOptions writes a new entry to the WAL and returns its LSN once
+// the requested durability has been reached.
+//
+// DurabilityNone behaves like WriteEntry, DurabilityFlush additionally flushes the
+// buffer to the operating system and DurabilityFsync behaves like WriteEntrySync.
+// Only the calling writer pays for the requested durability; other writers are
+// not forced to flush or sync.
+//
+// This method is thread-safe and can be called concurrently from multiple goroutines.
+func (w *WAL) WriteEntryWithOptions(data []byte, opts WriteOptions) (uint64, error) {
+	if !opts.Durability.valid() {
+		return 0, fmt.Errorf("unknown durability %d", opts.Durability)
+	}
+
+	lsn, err := w.writeEntry(data, false)
+	if err != nil {
+		return 0, err
+	}
+
+	switch opts.Durability {
+	case DurabilityNone:
+	case DurabilityFlush:
+		if err := w.flush(); err != nil {
+			return 0, fmt.Errorf("flush: %w", err)
+		}
+	case DurabilityFsync:
+		if err := w.commit(lsn); err != nil {
+			return 0, fmt.Errorf("commit: %w", err)
+		}
+	}
+
+	return lsn, nil
+}
+
+// WriteEntryWithMeta writes a new entry with metadata to the WAL and returns its LSN.
+//
+// The timestamp, record type, routing key and headers are stored in first-class
+// fields of the entry and covered by its CRC. A zero Timestamp is replaced by the
+// current time. The metadata is available to readers through WAL_Entry.Meta.
+//
+// This method is thread-safe and can be called concurrently from multiple goroutines.
+func (w *WAL) WriteEntryWithMeta(data []byte, meta EntryMeta) (uint64, error) {
+	if meta.Timestamp.IsZero() {
+		meta.Timestamp = time.Now()
+	}
+
+	entry := &WAL_Entry{Data: data}
+	entry.setMeta(meta)
+	return w.appendEntry(entry)
+}
+
+// WaitForDurable blocks until the entry with the given LSN has been synced to disk.
+//
+// WaitForDurable does not trigger a sync itself; it waits for the background sync
+// loop, a manual Sync or another writer's group commit to persist the entry. This
+// lets callers acknowledge a write only once it is durable without forcing every
+// other writer to sync.
+//
+// Returns ctx.Err() if the context is done first, ErrClosed if the WAL is closed
+// before the entry became durable, or an error wrapping ErrSyncFailed if a sync
+// failed before the entry became durable.
+func (w *WAL) WaitForDurable(ctx context.Context, lsn uint64) error {
+	for {
+		w.mu.Lock()
+		synced := w.syncedLSN
+		durable := w.durable
+		failure := w.failure
+		w.mu.Unlock()
+
+		if synced >= lsn {
+			return nil
+		}
+		if failure != nil {
+			return failure
+		}
+
+		select {
+		case <-durable:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-w.closed:
+			w.mu.Lock()
+			synced = w.syncedLSN
+			w.mu.Unlock()
+			if synced >= lsn {
+				return nil
+			}
+			return ErrClosed
+		}
+	}
+}
+
 // WriteCheckpoint writes a checkpoint entry to the WAL and returns its LSN.
 //
 // A checkpoint marks a known good state in the log. When reading with ReadFromCheckpoint,
@@ -233,81 +790,190 @@ func (w *WAL) WriteCheckpoint(data []byte) (uint64, error) {
 	return w.writeEntry(data, true)
 }
 
+// WriteBatch writes the entries of a batch atomically and returns the LSN of the
+// first entry.
+//
+// The entries are assigned contiguous LSNs under a single lock acquisition and
+// stored in a single checksummed record, so after a crash recovery either sees
+// the whole batch or none of it. The batch is checked for rotation once, and
+// may extend the segment past MaxSegmentSize. A batch cannot be appended to a
+// legacy segment, which is sealed first.
+//
+// An empty batch writes nothing and returns 0.
+//
+// This method is thread-safe and can be called concurrently from multiple goroutines.
+func (w *WAL) WriteBatch(batch *Batch) (uint64, error) {
+	if batch.Len() == 0 {
+		return 0, nil
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.failure != nil {
+		return 0, w.failure
+	}
+
+	// Check if rotation needed
+	if err := w.rotateIfNeeded(); err != nil {
+		return 0, fmt.Errorf("rotate: %w", err)
+	}
+	for !usesFrames(w.entryWriter.header) {
+		if w.syncRound != nil {
+			// Another writer may rotate while we wait, so check again
+			w.awaitSyncRound()
+			continue
+		}
+		if err := w.rotate(); err != nil {
+			return 0, fmt.Errorf("rotate legacy segment: %w", err)
+		}
+	}
+
+	// Create entries with contiguous LSNs in the current term
+	algo := segmentChecksum(w.entryWriter.header)
+	first := w.lastLSN + 1
+	entries := make([]*WAL_Entry, len(batch.entries))
+	for i, data := range batch.entries {
+		entry := &WAL_Entry{
+			LogSequenceNumber: first + uint64(i),
+			Data:              data,
+		}
+		if err := w.stampTerm(entry); err != nil {
+			return 0, err
+		}
+		entry.CRC = entryCRC(algo, entry)
+		entries[i] = entry
+	}
+
+	// Write entries, the LSNs are only consumed once the batch is buffered
+	if err := w.entryWriter.WriteBatch(entries); err != nil {
+		return 0, fmt.Errorf("write batch: %w", err)
+	}
+	w.lastLSN += uint64(len(entries))
+
+	return first, nil
+}
+
 // writeEntry writes a new entry to the WAL
 // it is used to write a new entry to the WAL
 // and rotates the segment if needed
 func (w *WAL) writeEntry(data []byte, isCheckpoint bool) (uint64, error) {
+	entry := &WAL_Entry{Data: data}
+	if isCheckpoint {
+		isCP := true
+		entry.IsCheckpoint = &isCP
+	}
+	return w.appendEntry(entry)
+}
+
+// appendEntry assigns the next LSN to an entry, computes its
+// CRC and writes it, rotating the segment if needed
+// checkpoints sync all prior entries first
+func (w *WAL) appendEntry(entry *WAL_Entry) (uint64, error) {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
+	if w.failure != nil {
+		return 0, w.failure
+	}
+
+	if entry.GetIsCheckpoint() {
+		// Sync before checkpoint, before the LSN is assigned since
+		// the lock is released while the sync is in flight
+		if err := w.commitLocked(w.lastLSN); err != nil {
+			return 0, fmt.Errorf("sync before checkpoint: %w", err)
+		}
+	}
+
 	// Check if rotation needed
 	if err := w.rotateIfNeeded(); err != nil {
 		return 0, fmt.Errorf("rotate: %w", err)
 	}
 
-	// Generate LSN
-	w.lastLSN++
-	lsn := w.lastLSN
+	// Reject writers of older terms
+	if err := w.stampTerm(entry); err != nil {
+		return 0, err
+	}
 
-	// Create entry
-	entry := NewEntry(lsn, data)
+	// Generate LSN, it is only consumed once the entry is buffered
+	entry.LogSequenceNumber = w.lastLSN + 1
 
-	if isCheckpoint {
-		// Sync before checkpoint
-		if err := w.entryWriter.Sync(); err != nil {
-			return 0, fmt.Errorf("sync before checkpoint: %w", err)
-		}
-		isCP := true
-		entry.IsCheckpoint = &isCP
+	if err := w.writeLocked(entry); err != nil {
+		return 0, err
 	}
+	w.lastLSN = entry.LogSequenceNumber
+	return entry.LogSequenceNumber, nil
+}
+
+// writeLocked computes the CRC of an entry whose LSN is assigned
+// and writes it to the current segment
+// it must be called with w.mu held
+func (w *WAL) writeLocked(entry *WAL_Entry) error {
+	entry.CRC = entryCRC(segmentChecksum(w.entryWriter.header), entry)
 
 	// Write entry
 	if err := w.entryWriter.WriteEntry(entry); err != nil {
-		return 0, fmt.Errorf("write entry: %w", err)
+		return fmt.Errorf("write entry: %w", err)
 	}
 
-	return lsn, nil
+	if entry.GetIsCheckpoint() {
+		w.checkpoint = &checkpointLocation{segmentID: w.currentSegment, lsn: entry.LogSequenceNumber}
+		w.checkpointScanned = true
+	}
+
+	return nil
 }
 
 // rotateIfNeeded checks if the current segment is full
 // and rotates the segment if needed
+// a segment is never closed while a group commit is syncing it
 func (w *WAL) rotateIfNeeded() error {
-	size, err := w.segmentMgr.CurrentSegmentSize(w.currentSegment)
-	if err != nil {
-		return err
-	}
+	for {
+		size, err := w.segmentMgr.CurrentSegmentSize(w.currentSegment)
+		if err != nil {
+			return err
+		}
 
-	buffered := int64(w.entryWriter.BufferedBytes())
-	if size+buffered < w.options.MaxSegmentSize {
-		return nil
-	}
+		buffered := int64(w.entryWriter.BufferedBytes())
+		if size+buffered < w.options.MaxSegmentSize {
+			return nil
+		}
 
-	return w.rotate()
+		if w.syncRound == nil {
+			return w.rotate()
+		}
+
+		// Another writer may rotate while we wait, so check again
+		w.awaitSyncRound()
+	}
 }
 
 // rotate rotates the current segment
 // and creates a new segment
-// it cleans up old segments if needed
+// it archives and cleans up old segments if needed
+// it must be called with no group commit in flight
 func (w *WAL) rotate() error {
-	// Sync and close current segment
-	if err := w.entryWriter.Sync(); err != nil {
-		return fmt.Errorf("sync before rotation: %w", err)
+	if w.failure != nil {
+		return w.failure
 	}
 
-	if err := w.currentWriter.Close(); err != nil {
-		return fmt.Errorf("close current segment: %w", err)
+	// Sync and close current segment, a segment is always
+	// synced when sealed under SyncPolicyOnRotate
+	policy := w.options.syncPolicy()
+	if policy == SyncPolicyOnRotate {
+		policy = SyncPolicyFsync
 	}
-
-	// Cleanup old segments if needed
-	segments, err := w.segmentMgr.ListSegments()
-	if err != nil {
-		return err
+	if err := w.entryWriter.Flush(); err != nil {
+		return fmt.Errorf("flush before rotation: %w", err)
+	}
+	w.markFlushed(w.lastLSN)
+	if err := w.entryWriter.syncFileWith(policy); err != nil {
+		return fmt.Errorf("sync before rotation: %w", w.failSync(err))
 	}
+	w.markSynced(w.lastLSN)
 
-	if len(segments) >= w.options.MaxSegments {
-		if err := w.segmentMgr.DeleteSegment(segments[0]); err != nil {
-			log.Printf("Warning: failed to delete old segment: %v", err)
-		}
+	if err := w.currentWriter.Close(); err != nil {
+		return fmt.Errorf("close current segment: %w", err)
 	}
 
 	// Create new segment
@@ -318,22 +984,92 @@ func (w *WAL) rotate() error {
 	}
 
 	w.currentWriter = writer
-	w.entryWriter = NewBinaryEntryWriter(writer)
+	w.entryWriter = w.newEntryWriter(writer)
 
+	if err := w.initSegment(); err != nil {
+		return err
+	}
+
+	// Archive and cleanup old segments in the background
+	w.scheduleRetention()
 	return nil
 }
 
-// Sync flushes buffered writes and syncs to disk if fsync is enabled.
+// initSegment writes the header of the current segment if it is new
+// the header records the LSN the segment starts at and, when
+// encryption is enabled, the ID of the current key
+func (w *WAL) initSegment() error {
+	size, err := w.segmentMgr.CurrentSegmentSize(w.currentSegment)
+	if err != nil {
+		return err
+	}
+	if size > 0 {
+		return nil
+	}
+
+	header := newSegmentHeader(w.lastLSN+1, w.options.Checksum)
+	if w.options.KeyProvider != nil {
+		keyID, err := w.options.KeyProvider.CurrentKeyID()
+		if err != nil {
+			return fmt.Errorf("current key: %w", err)
+		}
+		header.Flags |= SegmentFlagEncrypted
+		header.KeyID = keyID
+	}
+
+	if err := w.entryWriter.WriteHeader(header); err != nil {
+		return fmt.Errorf("write segment header: %w", err)
+	}
+	return w.entryWriter.Flush()
+}
+
+// rotateForEncryption rotates the current segment if encryption
+// is enabled and it is not encrypted with the current key
+// an encrypted segment cannot be opened without a key provider
+func (w *WAL) rotateForEncryption() error {
+	if w.options.KeyProvider == nil {
+		return nil
+	}
+
+	keyID, err := w.options.KeyProvider.CurrentKeyID()
+	if err != nil {
+		return fmt.Errorf("current key: %w", err)
+	}
+	header := w.entryWriter.header
+	if header != nil && header.Flags&SegmentFlagEncrypted != 0 && header.KeyID == keyID {
+		return nil
+	}
+	return w.rotate()
+}
+
+// newEntryWriter creates an entry writer for a segment
+// configured with the WAL options
+func (w *WAL) newEntryWriter(writer io.Writer) *BinaryEntryWriter {
+	entryWriter := NewBinaryEntryWriter(writer)
+	entryWriter.SetSyncPolicy(w.options.syncPolicy())
+	entryWriter.SetMaxRecordSize(w.options.maxRecordSize())
+	entryWriter.SetKeyProvider(w.options.KeyProvider)
+	// The codec was validated by Open
+	_ = entryWriter.SetCompression(w.options.Compression)
+	return entryWriter
+}
+
+// Sync flushes buffered writes and syncs to disk according to the sync policy.
 //
 // Sync is called automatically by the background sync loop at the configured
 // SyncInterval, but can also be called manually to ensure durability of recent writes.
 //
+// A failed sync is sticky: the operating system may already have dropped the
+// unsynced data, so Sync, every write and WaitForDurable of entries that were
+// not yet durable return an error wrapping ErrSyncFailed until the WAL is
+// reopened, which recovers what actually reached the disk.
+//
 // This method is thread-safe.
 func (w *WAL) Sync() error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
-	if err := w.entryWriter.Sync(); err != nil {
+	if err := w.commitLocked(w.lastLSN); err != nil {
 		return err
 	}
 
@@ -341,6 +1077,163 @@ func (w *WAL) Sync() error {
 	return nil
 }
 
+// syncRound is a single group commit
+// it is shared by every caller waiting on the same sync
+type syncRound struct {
+	// done is closed once the sync completes
+	done chan struct{}
+	// target is the highest LSN covered by the sync
+	target uint64
+	// err is the result of the sync
+	err error
+}
+
+// commit waits until the entry with the given LSN is synced to disk
+func (w *WAL) commit(lsn uint64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.commitLocked(lsn)
+}
+
+// commitLocked waits until the entry with the given LSN is synced to disk
+// joining the in-flight group commit or leading a new one
+// it must be called with w.mu held, which is released while syncing
+func (w *WAL) commitLocked(lsn uint64) error {
+	for w.syncedLSN < lsn {
+		if w.failure != nil {
+			return w.failure
+		}
+
+		round := w.syncRound
+		if round == nil {
+			return w.leadSyncRound()
+		}
+
+		// Follow the in-flight sync
+		w.awaitSyncRound()
+
+		// A failed sync is reported to every entry it covered
+		if round.err != nil && lsn <= round.target {
+			return round.err
+		}
+	}
+	return nil
+}
+
+// leadSyncRound flushes the buffer and syncs everything written so far
+// the sync itself runs without w.mu so writers can keep buffering
+// it must be called with w.mu held and no group commit in flight
+func (w *WAL) leadSyncRound() error {
+	round := &syncRound{
+		done:   make(chan struct{}),
+		target: w.lastLSN,
+	}
+	w.syncRound = round
+
+	round.err = w.entryWriter.Flush()
+	if round.err == nil {
+		w.markFlushed(round.target)
+		entryWriter := w.entryWriter
+		w.mu.Unlock()
+		err := entryWriter.syncFile()
+		w.mu.Lock()
+		if err != nil {
+			round.err = w.failSync(err)
+		}
+	}
+
+	if round.err == nil {
+		w.markSynced(round.target)
+	}
+	w.syncRound = nil
+	close(round.done)
+
+	return round.err
+}
+
+// markSynced records that every entry up to lsn is synced to disk
+// and wakes up callers of WaitForDurable
+// it must be called with w.mu held
+func (w *WAL) markSynced(lsn uint64) {
+	if lsn <= w.syncedLSN {
+		return
+	}
+	w.syncedLSN = lsn
+	close(w.durable)
+	w.durable = make(chan struct{})
+}
+
+// failSync records a failed sync, every later write and sync fails
+// since retrying fsync after a failure can falsely report success
+// for pages the kernel already dropped
+// it must be called with w.mu held
+func (w *WAL) failSync(err error) error {
+	return w.fail(fmt.Errorf("%w: %w", ErrSyncFailed, err))
+}
+
+// fail makes every later write and sync return err until the WAL
+// is reopened and wakes up callers of WaitForDurable
+// the first failure is kept and returned
+// it must be called with w.mu held
+func (w *WAL) fail(err error) error {
+	if w.failure == nil {
+		w.failure = err
+		close(w.durable)
+		w.durable = make(chan struct{})
+	}
+	return w.failure
+}
+
+// flush flushes buffered entries to the underlying segment
+func (w *WAL) flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.entryWriter.Flush(); err != nil {
+		return err
+	}
+	w.markFlushed(w.lastLSN)
+	return nil
+}
+
+// markFlushed records that every entry up to lsn is flushed to its
+// segment and wakes up subscriptions
+// it must be called with w.mu held
+func (w *WAL) markFlushed(lsn uint64) {
+	if lsn <= w.flushedLSN {
+		return
+	}
+	w.flushedLSN = lsn
+	close(w.flushed)
+	w.flushed = make(chan struct{})
+}
+
+// rewindSubscriptions makes the running subscriptions continue
+// from lsn+1 and wakes them up, after entries past lsn were removed
+// it must be called with w.mu held
+func (w *WAL) rewindSubscriptions(lsn uint64) {
+	for sub := range w.subscriptions {
+		if !sub.rewound || lsn < sub.rewindTo {
+			sub.rewound = true
+			sub.rewindTo = lsn
+		}
+	}
+	close(w.flushed)
+	w.flushed = make(chan struct{})
+}
+
+// awaitSyncRound waits for the in-flight group commit to finish
+// it must be called with w.mu held, which is released while waiting
+func (w *WAL) awaitSyncRound() {
+	for w.syncRound != nil {
+		round := w.syncRound
+		w.mu.Unlock()
+		<-round.done
+		w.mu.Lock()
+	}
+}
+
 // syncLoop is the loop for the WAL
 // it is used to sync the WAL to disk
 // at the specified interval
@@ -364,7 +1257,10 @@ func (w *WAL) syncLoop() {
 // and flushing all buffered data to disk.
 //
 // Close must be called to ensure all data is durably stored. After Close is called,
-// the WAL should not be used.
+// the WAL should not be used, and closing it again is a no-op. Segment managers
+// implementing io.Closer are closed too, which releases the directory lock of a
+// FileSegmentManager. The segment and the segment manager are closed even if the
+// final sync fails, and every error is returned joined.
 func (w *WAL) Close() error {
 	w.cancel()
 	w.wg.Wait()
@@ -372,42 +1268,62 @@ func (w *WAL) Close() error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
-	if err := w.entryWriter.Sync(); err != nil {
-		return err
+	// Closing again is a no-op
+	select {
+	case <-w.closed:
+		return nil
+	default:
 	}
+	defer close(w.closed)
 
-	return w.currentWriter.Close()
+	// The segment and the segment manager are closed
+	// even when the final sync fails
+	var errs []error
+	w.awaitSyncRound()
+	if w.failure != nil {
+		errs = append(errs, w.failure)
+	} else if err := w.entryWriter.Flush(); err != nil {
+		errs = append(errs, err)
+	} else if err := w.entryWriter.syncFile(); err != nil {
+		errs = append(errs, w.failSync(err))
+	} else {
+		w.markFlushed(w.lastLSN)
+		w.markSynced(w.lastLSN)
+	}
+
+	if err := w.currentWriter.Close(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := closeSegmentManager(w.segmentMgr); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// closeSegmentManager closes a segment manager implementing io.Closer
+func closeSegmentManager(segmentMgr SegmentManager) error {
+	if closer, ok := segmentMgr.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
 }
 
 // ReadAll reads all entries from all segments in order.
 //
 // This method reads every entry across all segment files, verifying CRC checksums
 // and returning them in LSN order. Use ReadFromCheckpoint for faster recovery that
-// skips entries before the last checkpoint.
+// skips entries before the last checkpoint, or ReadFrom to stream entries without
+// holding the whole log in memory.
 //
 // This method is safe to call while the WAL is actively being written to.
 func (w *WAL) ReadAll() ([]*WAL_Entry, error) {
-	segments, err := w.segmentMgr.ListSegments()
-	if err != nil {
-		return nil, err
-	}
-
 	var entries []*WAL_Entry
 
-	for _, segID := range segments {
-		reader, err := w.segmentMgr.OpenSegment(segID)
-		if err != nil {
-			return nil, fmt.Errorf("open segment %d: %w", segID, err)
-		}
-
-		segEntries, err := ReadAllEntries(reader)
-		reader.Close()
-
+	for entry, err := range w.Entries(0) {
 		if err != nil {
-			return nil, fmt.Errorf("read segment %d: %w", segID, err)
+			return nil, err
 		}
-
-		entries = append(entries, segEntries...)
+		entries = append(entries, entry)
 	}
 
 	return entries, nil
@@ -423,33 +1339,18 @@ func (w *WAL) ReadAll() ([]*WAL_Entry, error) {
 //
 // This method is safe to call while the WAL is actively being written to.
 func (w *WAL) ReadFromCheckpoint() ([]*WAL_Entry, error) {
-	segments, err := w.segmentMgr.ListSegments()
-	if err != nil {
-		return nil, err
-	}
-
 	var entries []*WAL_Entry
-	var checkpointLSN uint64
-
-	for _, segID := range segments {
-		reader, err := w.segmentMgr.OpenSegment(segID)
-		if err != nil {
-			return nil, fmt.Errorf("open segment %d: %w", segID, err)
-		}
-
-		segEntries, cpLSN, err := ReadEntriesWithCheckpoint(reader)
-		reader.Close()
 
+	for entry, err := range w.Entries(0) {
 		if err != nil {
-			return nil, fmt.Errorf("read segment %d: %w", segID, err)
+			return nil, err
 		}
 
-		// If we found a checkpoint, reset entries
-		if cpLSN > checkpointLSN {
-			entries = segEntries
-			checkpointLSN = cpLSN
+		if entry.IsCheckpoint != nil && *entry.IsCheckpoint {
+			// Reset entries from checkpoint
+			entries = []*WAL_Entry{entry}
 		} else {
-			entries = append(entries, segEntries...)
+			entries = append(entries, entry)
 		}
 	}
 
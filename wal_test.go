@@ -0,0 +1,746 @@
+package wal
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testOptions returns options keeping every segment
+func testOptions() WALOptions {
+	opts := DefaultWALOptions()
+	opts.MaxSegments = 0
+	return opts
+}
+
+// openTestWAL opens a WAL on a new FileSegmentManager for dir
+func openTestWAL(t *testing.T, dir string, opts WALOptions) *WAL {
+	t.Helper()
+
+	segmentMgr, err := NewFileSegmentManager(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := Open(segmentMgr, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { w.Close() })
+	return w
+}
+
+// writeEntries writes n entries and returns their LSNs
+func writeEntries(t *testing.T, w *WAL, n int) []uint64 {
+	t.Helper()
+
+	lsns := make([]uint64, n)
+	for i := range lsns {
+		lsn, err := w.WriteEntry([]byte(fmt.Sprintf("entry %d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		lsns[i] = lsn
+	}
+	return lsns
+}
+
+// readLSNs returns the LSNs of every entry of the WAL
+func readLSNs(t *testing.T, w *WAL) []uint64 {
+	t.Helper()
+
+	if err := w.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	var lsns []uint64
+	for entry, err := range w.Entries(0) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		lsns = append(lsns, entry.LogSequenceNumber)
+	}
+	return lsns
+}
+
+// segmentPath returns the path of a segment file in dir
+func segmentPath(dir string, id int) string {
+	return filepath.Join(dir, fmt.Sprintf("%s%d", segmentPrefix, id))
+}
+
+// flipByte inverts the byte at offset in the file
+func flipByte(t *testing.T, path string, offset int64) {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offset < 0 {
+		offset += int64(len(data))
+	}
+	data[offset] ^= 0xff
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOpenTruncatesPartialRecord(t *testing.T) {
+	dir := t.TempDir()
+	w := openTestWAL(t, dir, testOptions())
+	writeEntries(t, w, 10)
+	w.Close()
+
+	path := segmentPath(dir, 0)
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(path, info.Size()-3); err != nil {
+		t.Fatal(err)
+	}
+
+	w = openTestWAL(t, dir, testOptions())
+	if got := w.LastLSN(); got != 9 {
+		t.Fatalf("LastLSN() = %d, want 9", got)
+	}
+	rec := w.TailRecovery()
+	if rec == nil || !errors.Is(rec.Err, ErrTruncatedEntry) {
+		t.Fatalf("TailRecovery() = %+v, want a truncated entry", rec)
+	}
+	if lsn, err := w.WriteEntry([]byte("next")); err != nil || lsn != 10 {
+		t.Fatalf("WriteEntry() = %d, %v, want 10", lsn, err)
+	}
+}
+
+func TestOpenTruncatesTrailingChecksumMismatch(t *testing.T) {
+	dir := t.TempDir()
+	w := openTestWAL(t, dir, testOptions())
+	writeEntries(t, w, 10)
+	w.Close()
+
+	flipByte(t, segmentPath(dir, 0), -1)
+
+	w = openTestWAL(t, dir, testOptions())
+	if got := w.LastLSN(); got != 9 {
+		t.Fatalf("LastLSN() = %d, want 9", got)
+	}
+	if rec := w.TailRecovery(); rec == nil || !errors.Is(rec.Err, ErrCRCMismatch) {
+		t.Fatalf("TailRecovery() = %+v, want a CRC mismatch", rec)
+	}
+}
+
+func TestOpenFailsOnCorruptionBeforeTail(t *testing.T) {
+	dir := t.TempDir()
+	w := openTestWAL(t, dir, testOptions())
+	writeEntries(t, w, 10)
+	w.Close()
+
+	path := segmentPath(dir, 0)
+	before, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Damage the payload of the first entry
+	flipByte(t, path, SegmentHeaderSize+frameHeaderSize+2)
+
+	segmentMgr, err := NewFileSegmentManager(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer segmentMgr.Close()
+	_, err = Open(segmentMgr, testOptions())
+	var corruption *CorruptionError
+	if !errors.As(err, &corruption) || corruption.Offset != SegmentHeaderSize {
+		t.Fatalf("Open() error = %v, want a CorruptionError at offset %d", err, SegmentHeaderSize)
+	}
+
+	after, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Size() != before.Size() {
+		t.Fatalf("segment size = %d, want %d", after.Size(), before.Size())
+	}
+}
+
+// setFrameLength overwrites the length of the frame at offset in the file
+// and updates the header checksum if fixChecksum is set
+func setFrameLength(t *testing.T, path string, offset int64, length uint32, fixChecksum bool) {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fh := decodeFrameHeader(data[offset:])
+	fh.length = length
+	if fixChecksum {
+		fh.headerChecksum = frameHeaderChecksum(ChecksumCRC32C, fh.length, fh.flags)
+	}
+	fh.encode(data[offset:])
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOpenCorruptedFrameLength(t *testing.T) {
+	tests := []struct {
+		name string
+		// frame is the damaged frame of the five written
+		frame int64
+		// fixChecksum is whether the header checksum matches the length
+		fixChecksum bool
+		// torn is whether the frame is a torn tail
+		torn bool
+	}{
+		{"before tail", 1, false, false},
+		{"before tail with valid header", 1, true, false},
+		{"last frame", 4, false, true},
+		{"last frame with valid header", 4, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			w := openTestWAL(t, dir, testOptions())
+			writeEntries(t, w, 5)
+			w.Close()
+
+			path := segmentPath(dir, 0)
+			before, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			frameSize := (before.Size() - SegmentHeaderSize) / 5
+			offset := SegmentHeaderSize + tt.frame*frameSize
+			setFrameLength(t, path, offset, 100000, tt.fixChecksum)
+
+			segmentMgr, err := NewFileSegmentManager(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer segmentMgr.Close()
+			w, err = Open(segmentMgr, testOptions())
+			if tt.torn {
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer w.Close()
+				if got := w.LastLSN(); got != 4 {
+					t.Fatalf("LastLSN() = %d, want 4", got)
+				}
+				return
+			}
+
+			var corruption *CorruptionError
+			if !errors.As(err, &corruption) || corruption.Offset != offset {
+				t.Fatalf("Open() error = %v, want a CorruptionError at offset %d", err, offset)
+			}
+			after, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if after.Size() != before.Size() {
+				t.Fatalf("segment size = %d, want %d", after.Size(), before.Size())
+			}
+		})
+	}
+}
+
+func TestOpenCorruptedLengthInLegacySegment(t *testing.T) {
+	dir := t.TempDir()
+	data := legacySegment(t, 1, 2, 3, 4, 5)
+	recordSize := len(data) / 5
+	binary.LittleEndian.PutUint32(data[recordSize:], 100000)
+	if err := os.WriteFile(segmentPath(dir, 0), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	segmentMgr, err := NewFileSegmentManager(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer segmentMgr.Close()
+	_, err = Open(segmentMgr, testOptions())
+	var corruption *CorruptionError
+	if !errors.As(err, &corruption) || !errors.Is(err, ErrTruncatedEntry) {
+		t.Fatalf("Open() error = %v, want a CorruptionError wrapping ErrTruncatedEntry", err)
+	}
+}
+
+// errInjected is the error returned by failing test writers
+var errInjected = errors.New("injected failure")
+
+// faultySegmentManager is a FileSegmentManager whose segment
+// writers fail once failWrites is set, and which records Close
+type faultySegmentManager struct {
+	*FileSegmentManager
+	// failWrites makes segment writes fail
+	failWrites atomic.Bool
+	// failTruncate makes TruncateSegment fail
+	failTruncate atomic.Bool
+	// failDelete makes DeleteSegment fail
+	failDelete atomic.Bool
+	// failSyncs makes segment syncs fail
+	failSyncs atomic.Bool
+	// closed is whether Close was called
+	closed atomic.Bool
+	// writers are the segment writers created
+	writers []*faultyWriter
+	// syncs counts the segment syncs
+	syncs atomic.Int64
+	// holdSyncs makes segment syncs wait for releaseSyncs
+	holdSyncs atomic.Bool
+	// syncHeld is signaled when a sync starts waiting
+	syncHeld chan struct{}
+	// releaseSyncs is closed to release held syncs
+	releaseSyncs chan struct{}
+}
+
+// faultyWriter is a segment writer of a faultySegmentManager
+type faultyWriter struct {
+	io.WriteCloser
+	// mgr is the manager that created the writer
+	mgr *faultySegmentManager
+	// closed is whether Close was called
+	closed atomic.Bool
+}
+
+func (m *faultySegmentManager) CreateSegment(id int) (io.WriteCloser, error) {
+	writer, err := m.FileSegmentManager.CreateSegment(id)
+	if err != nil {
+		return nil, err
+	}
+	fw := &faultyWriter{WriteCloser: writer, mgr: m}
+	m.writers = append(m.writers, fw)
+	return fw, nil
+}
+
+func (m *faultySegmentManager) TruncateSegment(id int, size int64) error {
+	if m.failTruncate.Load() {
+		return errInjected
+	}
+	return m.FileSegmentManager.TruncateSegment(id, size)
+}
+
+func (m *faultySegmentManager) DeleteSegment(id int) error {
+	if m.failDelete.Load() {
+		return errInjected
+	}
+	return m.FileSegmentManager.DeleteSegment(id)
+}
+
+func (m *faultySegmentManager) Close() error {
+	m.closed.Store(true)
+	return m.FileSegmentManager.Close()
+}
+
+func (fw *faultyWriter) Write(p []byte) (int, error) {
+	if fw.mgr.failWrites.Load() {
+		return 0, errInjected
+	}
+	return fw.WriteCloser.Write(p)
+}
+
+func (fw *faultyWriter) Sync() error {
+	fw.mgr.syncs.Add(1)
+	if fw.mgr.holdSyncs.Load() {
+		fw.mgr.syncHeld <- struct{}{}
+		<-fw.mgr.releaseSyncs
+	}
+	if fw.mgr.failSyncs.Load() {
+		return errInjected
+	}
+	return fw.WriteCloser.(syncer).Sync()
+}
+
+func (fw *faultyWriter) Close() error {
+	fw.closed.Store(true)
+	return fw.WriteCloser.Close()
+}
+
+// openFaultyWAL opens a WAL in dir on a faultySegmentManager
+func openFaultyWAL(t *testing.T, dir string, opts WALOptions) (*WAL, *faultySegmentManager) {
+	t.Helper()
+
+	fsm, err := NewFileSegmentManager(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	segmentMgr := &faultySegmentManager{
+		FileSegmentManager: fsm,
+		syncHeld:           make(chan struct{}, 1),
+		releaseSyncs:       make(chan struct{}),
+	}
+	w, err := Open(segmentMgr, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w, segmentMgr
+}
+
+// crash stops the background loops of a WAL and releases its
+// directory without syncing, as if the process died
+func crash(w *WAL) {
+	w.cancel()
+	w.wg.Wait()
+	w.currentWriter.Close()
+	if closer, ok := w.segmentMgr.(io.Closer); ok {
+		closer.Close()
+	}
+}
+
+func TestWriteEntryWithOptionsRejectsUnknownDurability(t *testing.T) {
+	w := openTestWAL(t, t.TempDir(), testOptions())
+
+	if _, err := w.WriteEntryWithOptions([]byte("a"), WriteOptions{Durability: 42}); err == nil {
+		t.Fatal("WriteEntryWithOptions() succeeded with an unknown durability")
+	}
+	if got := w.LastLSN(); got != 0 {
+		t.Fatalf("LastLSN() = %d, want 0", got)
+	}
+}
+
+func TestCloseReleasesResourcesWhenSyncFails(t *testing.T) {
+	dir := t.TempDir()
+	w, segmentMgr := openFaultyWAL(t, dir, testOptions())
+	writeEntries(t, w, 3)
+
+	segmentMgr.failWrites.Store(true)
+	if err := w.Close(); !errors.Is(err, errInjected) {
+		t.Fatalf("Close() error = %v, want the injected failure", err)
+	}
+	if !segmentMgr.writers[len(segmentMgr.writers)-1].closed.Load() {
+		t.Fatal("segment writer was not closed")
+	}
+	if !segmentMgr.closed.Load() {
+		t.Fatal("segment manager was not closed")
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("second Close() error = %v", err)
+	}
+
+	// The directory lock was released
+	openTestWAL(t, dir, testOptions())
+}
+
+func TestCloseTwice(t *testing.T) {
+	dir := t.TempDir()
+	w := openTestWAL(t, dir, testOptions())
+	writeEntries(t, w, 3)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A second Close must not release the lock of the next writer
+	openTestWAL(t, dir, testOptions())
+	if err := w.Close(); err != nil {
+		t.Fatalf("second Close() error = %v", err)
+	}
+
+	segmentMgr, err := NewFileSegmentManager(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(segmentMgr, testOptions()); !errors.Is(err, ErrLocked) {
+		t.Fatalf("Open() error = %v, want ErrLocked", err)
+	}
+}
+
+func TestWriteEntrySyncGroupCommit(t *testing.T) {
+	opts := testOptions()
+	opts.SyncPolicy = SyncPolicyFsync
+	opts.SyncInterval = time.Hour
+	w, segmentMgr := openFaultyWAL(t, t.TempDir(), opts)
+	defer w.Close()
+
+	// The first writer leads a sync that waits
+	segmentMgr.syncs.Store(0)
+	segmentMgr.holdSyncs.Store(true)
+	errs := make(chan error, 11)
+	go func() {
+		_, err := w.WriteEntrySync([]byte("leader"))
+		errs <- err
+	}()
+	<-segmentMgr.syncHeld
+	segmentMgr.holdSyncs.Store(false)
+
+	// Writers arriving during the sync share the next one
+	for i := range 10 {
+		go func() {
+			_, err := w.WriteEntrySync([]byte(fmt.Sprintf("entry %d", i)))
+			errs <- err
+		}()
+	}
+	for w.LastLSN() < 11 {
+		time.Sleep(time.Millisecond)
+	}
+	close(segmentMgr.releaseSyncs)
+
+	for range 11 {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := segmentMgr.syncs.Load(); got != 2 {
+		t.Fatalf("11 writes took %d syncs, want 2", got)
+	}
+	if got := readLSNs(t, w); !slices.Equal(got, lsnRange(1, 11)) {
+		t.Fatalf("LSNs = %v, want 1 to 11", got)
+	}
+}
+
+func TestWaitForDurable(t *testing.T) {
+	opts := testOptions()
+	opts.SyncInterval = time.Hour
+	w := openTestWAL(t, t.TempDir(), opts)
+
+	lsn, err := w.WriteEntry([]byte("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := w.WaitForDurable(ctx, lsn); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("WaitForDurable() error = %v, want the deadline", err)
+	}
+
+	done := make(chan error)
+	go func() { done <- w.WaitForDurable(context.Background(), lsn) }()
+	if err := w.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("WaitForDurable() error = %v after Sync", err)
+	}
+
+	// Close syncs written entries but nothing after them
+	lsn, err = w.WriteEntry([]byte("b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { done <- w.WaitForDurable(context.Background(), lsn+1) }()
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WaitForDurable(context.Background(), lsn); err != nil {
+		t.Fatalf("WaitForDurable() error = %v after Close", err)
+	}
+	if err := <-done; !errors.Is(err, ErrClosed) {
+		t.Fatalf("WaitForDurable() error = %v, want ErrClosed", err)
+	}
+}
+
+func TestSyncFailureIsSticky(t *testing.T) {
+	dir := t.TempDir()
+	opts := testOptions()
+	opts.SyncInterval = time.Hour
+	w, segmentMgr := openFaultyWAL(t, dir, opts)
+
+	if _, err := w.WriteEntrySync([]byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	lsn, err := w.WriteEntry([]byte("b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() { done <- w.WaitForDurable(context.Background(), lsn) }()
+
+	segmentMgr.failSyncs.Store(true)
+	if err := w.Sync(); !errors.Is(err, ErrSyncFailed) || !errors.Is(err, errInjected) {
+		t.Fatalf("Sync() error = %v, want ErrSyncFailed", err)
+	}
+	if err := <-done; !errors.Is(err, ErrSyncFailed) {
+		t.Fatalf("WaitForDurable() error = %v, want ErrSyncFailed", err)
+	}
+
+	// A later successful sync does not make the entry durable
+	segmentMgr.failSyncs.Store(false)
+	if _, err := w.WriteEntrySync([]byte("c")); !errors.Is(err, ErrSyncFailed) {
+		t.Fatalf("WriteEntrySync() error = %v, want ErrSyncFailed", err)
+	}
+	if _, err := w.WriteEntry([]byte("c")); !errors.Is(err, ErrSyncFailed) {
+		t.Fatalf("WriteEntry() error = %v, want ErrSyncFailed", err)
+	}
+	var batch Batch
+	batch.Add([]byte("c"))
+	if _, err := w.WriteBatch(&batch); !errors.Is(err, ErrSyncFailed) {
+		t.Fatalf("WriteBatch() error = %v, want ErrSyncFailed", err)
+	}
+	if err := w.Sync(); !errors.Is(err, ErrSyncFailed) {
+		t.Fatalf("Sync() error = %v, want ErrSyncFailed", err)
+	}
+	if err := w.WaitForDurable(context.Background(), lsn); !errors.Is(err, ErrSyncFailed) {
+		t.Fatalf("WaitForDurable(%d) error = %v, want ErrSyncFailed", lsn, err)
+	}
+	if err := w.WaitForDurable(context.Background(), lsn-1); err != nil {
+		t.Fatalf("WaitForDurable(%d) error = %v, it was synced before the failure", lsn-1, err)
+	}
+	if got := w.LastLSN(); got != lsn {
+		t.Fatalf("LastLSN() = %d, want %d", got, lsn)
+	}
+	if err := w.Close(); !errors.Is(err, ErrSyncFailed) {
+		t.Fatalf("Close() error = %v, want ErrSyncFailed", err)
+	}
+
+	// Reopening recovers what reached the disk
+	w = openTestWAL(t, dir, opts)
+	if _, err := w.WriteEntrySync([]byte("c")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWriteEntryWithOptionsFlush(t *testing.T) {
+	dir := t.TempDir()
+	opts := testOptions()
+	opts.SyncInterval = time.Hour
+	w := openTestWAL(t, dir, opts)
+
+	if _, err := w.WriteEntryWithOptions([]byte("a"), WriteOptions{Durability: DurabilityFlush}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The entry is readable by another process
+	data, err := os.ReadFile(segmentPath(dir, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := ReadAllEntries(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || string(entries[0].Data) != "a" {
+		t.Fatalf("segment holds %v, want the flushed entry", entries)
+	}
+}
+
+func TestWriteEntryWithMeta(t *testing.T) {
+	dir := t.TempDir()
+	w := openTestWAL(t, dir, testOptions())
+
+	meta := EntryMeta{
+		Timestamp:  time.Unix(1700000000, 5),
+		RecordType: 7,
+		RoutingKey: []byte("key"),
+		Headers:    map[string]string{"a": "1", "b": "2"},
+	}
+	if _, err := w.WriteEntryWithMeta([]byte("meta"), meta); err != nil {
+		t.Fatal(err)
+	}
+	before := time.Now()
+	if _, err := w.WriteEntryWithMeta([]byte("now"), EntryMeta{}); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	w = openTestWAL(t, dir, testOptions())
+	entry, err := w.Get(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := entry.Meta()
+	if !got.Timestamp.Equal(meta.Timestamp) || got.RecordType != meta.RecordType ||
+		!bytes.Equal(got.RoutingKey, meta.RoutingKey) || !maps.Equal(got.Headers, meta.Headers) {
+		t.Fatalf("Meta() = %+v, want %+v", got, meta)
+	}
+
+	// The metadata is covered by the CRC
+	entry.Headers["a"] = "changed"
+	if err := VerifyEntryWith(entry, w.options.Checksum); !errors.Is(err, ErrCRCMismatch) {
+		t.Fatalf("VerifyEntryWith() error = %v, want ErrCRCMismatch", err)
+	}
+
+	entry, err = w.Get(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := entry.Meta(); got.Timestamp.Before(before.Round(0)) || got.RecordType != 0 || got.RoutingKey != nil || got.Headers != nil {
+		t.Fatalf("Meta() = %+v, want only a current timestamp", got)
+	}
+}
+
+func TestSyncPolicies(t *testing.T) {
+	tests := []struct {
+		name        string
+		policy      SyncPolicy
+		enableFsync bool
+		// syncs is whether Sync syncs the segment
+		syncs bool
+		// files is whether the segments are written to their files
+		// directly, so that fdatasync gets their descriptor
+		files bool
+	}{
+		{"default", SyncPolicyDefault, false, false, false},
+		{"default with EnableFsync", SyncPolicyDefault, true, true, false},
+		{"fsync", SyncPolicyFsync, false, true, false},
+		{"fdatasync", SyncPolicyFdatasync, false, true, true},
+		{"none", SyncPolicyNone, true, false, false},
+		{"on rotate", SyncPolicyOnRotate, true, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			opts := testOptions()
+			opts.SyncPolicy = tt.policy
+			opts.EnableFsync = tt.enableFsync
+			opts.SyncInterval = time.Hour
+
+			if tt.files {
+				w := openTestWAL(t, dir, opts)
+				writeEntries(t, w, 3)
+				if err := w.Sync(); err != nil {
+					t.Fatal(err)
+				}
+				if _, ok := w.entryWriter.syncWriter.(*os.File); !ok {
+					t.Fatalf("segment writer is a %T, want an *os.File", w.entryWriter.syncWriter)
+				}
+				w.Close()
+				w = openTestWAL(t, dir, opts)
+				if got := readLSNs(t, w); !slices.Equal(got, lsnRange(1, 3)) {
+					t.Fatalf("LSNs = %v, want 1 to 3", got)
+				}
+				return
+			}
+
+			w, segmentMgr := openFaultyWAL(t, dir, opts)
+			defer w.Close()
+
+			segmentMgr.syncs.Store(0)
+			writeEntries(t, w, 3)
+			if err := w.Sync(); err != nil {
+				t.Fatal(err)
+			}
+			if got := segmentMgr.syncs.Load() > 0; got != tt.syncs {
+				t.Fatalf("Sync() synced the segment: %v, want %v", got, tt.syncs)
+			}
+
+			// A sealed segment is always synced under SyncPolicyOnRotate
+			if tt.policy == SyncPolicyOnRotate {
+				w.mu.Lock()
+				err := w.rotate()
+				w.mu.Unlock()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if segmentMgr.syncs.Load() == 0 {
+					t.Fatal("rotation did not sync the sealed segment")
+				}
+			}
+
+			w.Close()
+			w = openTestWAL(t, dir, opts)
+			if got := readLSNs(t, w); !slices.Equal(got, lsnRange(1, 3)) {
+				t.Fatalf("LSNs = %v, want 1 to 3", got)
+			}
+		})
+	}
+}